@@ -6,6 +6,7 @@ toolchain go1.24.9
 
 require (
 	github.com/google/uuid v1.6.0
+	github.com/gorilla/websocket v1.5.1
 	github.com/kataras/iris/v12 v12.2.11
 	github.com/sirupsen/logrus v1.9.3
 	golang.org/x/exp v0.0.0-20251023183803-a4bb9ffd2546
@@ -0,0 +1,115 @@
+package broker
+
+import (
+	"hash/fnv"
+	"sync"
+
+	"github.com/MrReality255/turbo-go/tg/utils"
+)
+
+type BalanceCandidate struct {
+	Handle   Handle
+	InFlight int
+}
+
+// IBalancer chooses the receiver of a message that is addressed to a type only. The candidates
+// are sorted by handle; Pick returns the index of the chosen one.
+type IBalancer[Command ICommand] interface {
+	Pick(cmd Command, candidates []BalanceCandidate) int
+}
+
+type roundRobinBalancer[Command ICommand] struct {
+	mx       sync.Mutex
+	counters map[uint32]int
+}
+
+type leastInFlightBalancer[Command ICommand] struct{}
+
+type hashBalancer[Command ICommand] struct {
+	getKey func(cmd Command) string
+}
+
+func NewRoundRobinBalancer[Command ICommand]() IBalancer[Command] {
+	return &roundRobinBalancer[Command]{counters: make(map[uint32]int)}
+}
+
+func NewLeastInFlightBalancer[Command ICommand]() IBalancer[Command] {
+	return &leastInFlightBalancer[Command]{}
+}
+
+// NewHashBalancer sends commands with the same key to the same member, using rendezvous hashing
+// so only the keys of a removed member move to other members
+func NewHashBalancer[Command ICommand](descriptor CommandDescriptor[Command]) IBalancer[Command] {
+	if descriptor.GetKey == nil {
+		panic("hash balancer requires CommandDescriptor.GetKey")
+	}
+	return &hashBalancer[Command]{getKey: descriptor.GetKey}
+}
+
+func WithBalancer[Command ICommand](balancer IBalancer[Command]) Option[Command] {
+	return func(c *controller[Command]) {
+		c.balancer = balancer
+	}
+}
+
+func (b *roundRobinBalancer[Command]) Pick(_ Command, candidates []BalanceCandidate) int {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	typeID := candidates[0].Handle.GetTypeID()
+	idx := b.counters[typeID] % len(candidates)
+	b.counters[typeID] = idx + 1
+	return idx
+}
+
+func (b *leastInFlightBalancer[Command]) Pick(_ Command, candidates []BalanceCandidate) int {
+	result := 0
+	for idx, c := range candidates {
+		if c.InFlight < candidates[result].InFlight {
+			result = idx
+		}
+	}
+	return result
+}
+
+func (b *hashBalancer[Command]) Pick(cmd Command, candidates []BalanceCandidate) int {
+	var (
+		key       = b.getKey(cmd)
+		result    int
+		maxWeight uint64
+	)
+	for idx, c := range candidates {
+		hasher := fnv.New64a()
+		_, _ = hasher.Write([]byte(key))
+		utils.IgnoreErr(utils.WriteBytes(hasher, c.Handle))
+		if weight := hasher.Sum64(); idx == 0 || weight > maxWeight {
+			result, maxWeight = idx, weight
+		}
+	}
+	return result
+}
+
+func (c *controller[Command]) pickMemberLocked(
+	msg *messageWrapper[Command], typeID uint32,
+) (_ *memberWrapper[Command], hasMatch bool) {
+	var members []*memberWrapper[Command]
+	for id, m := range c.members {
+		if id.GetTypeID() != typeID {
+			continue
+		}
+		hasMatch = true
+		if m.accepts(msg) {
+			members = append(members, m)
+		}
+	}
+	if len(members) == 0 {
+		return nil, hasMatch
+	}
+
+	utils.SortArray(members, func(item1 *memberWrapper[Command], item2 *memberWrapper[Command]) bool {
+		return item1.id < item2.id
+	})
+	candidates := utils.ArrayMap(members, func(m *memberWrapper[Command]) BalanceCandidate {
+		return BalanceCandidate{Handle: m.id, InFlight: int(m.inFlight.Load())}
+	})
+	return members[c.balancer.Pick(msg.cmd, candidates)], true
+}
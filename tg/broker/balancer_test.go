@@ -0,0 +1,59 @@
+package broker
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MrReality255/turbo-go/tg/utils"
+)
+
+func TestBalancer(t *testing.T) {
+	descriptor := testDescriptor
+	descriptor.GetKey = func(cmd testCmd) string {
+		return cmd.Payload
+	}
+
+	for idx, tc := range []struct {
+		balancer IBalancer[testCmd]
+		payloads []string
+		result   string
+	}{
+		{
+			balancer: NewRoundRobinBalancer[testCmd](),
+			payloads: []string{"a", "b", "c", "d", "e", "f"},
+			result:   "1,2,3,1,2,3",
+		},
+		{
+			balancer: NewHashBalancer(descriptor),
+			payloads: []string{"a", "b", "a", "b", "a", "b"},
+			result:   "1,2,1,2,1,2",
+		},
+	} {
+		b := New(descriptor, time.Second, WithBalancer(tc.balancer))
+		for i := uint32(1); i <= 3; i++ {
+			b.AddMember(NewHandle(testTypeWorker, i), func(sender Handle, msg testCmd, member IMember[testCmd]) {
+				utils.MustSucceed(member.Send(sender, msg.reply(fmt.Sprint(i))))
+			})
+		}
+		client := b.AddMember(NewHandle(testTypeClient, 1), nil)
+
+		result := make([]string, 0, len(tc.payloads))
+		for _, payload := range tc.payloads {
+			r := utils.Must(client.Request(NewHandleType(testTypeWorker), newTestCmd(testTypeWorker, payload)))
+			result = append(result, r.Payload)
+		}
+		utils.TestString(t, idx+1, "balancer", tc.result, strings.Join(result, ","))
+		b.Close()
+	}
+}
+
+func TestLeastInFlightBalancer(t *testing.T) {
+	idx := NewLeastInFlightBalancer[testCmd]().Pick(testCmd{}, []BalanceCandidate{
+		{Handle: NewHandle(testTypeWorker, 1), InFlight: 3},
+		{Handle: NewHandle(testTypeWorker, 2), InFlight: 1},
+		{Handle: NewHandle(testTypeWorker, 3), InFlight: 2},
+	})
+	utils.TestAsString(t, 1, "least in flight", "1", idx)
+}
@@ -0,0 +1,357 @@
+package broker
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/MrReality255/turbo-go/tg/comm"
+	"github.com/MrReality255/turbo-go/tg/log"
+	"github.com/MrReality255/turbo-go/tg/utils"
+)
+
+const (
+	maxBridgeTypes = 1 << 16
+	maxPayloadSize = 64 << 20
+)
+
+var (
+	ErrBridgeClosed = errors.New("bridge link closed")
+	ErrBridgeFrame  = errors.New("invalid bridge frame")
+)
+
+type bridgeFrameKind uint8
+
+const (
+	bridgeFrameJoin        bridgeFrameKind = 1
+	bridgeFrameLeave       bridgeFrameKind = 2
+	bridgeFrameSubscribe   bridgeFrameKind = 3
+	bridgeFrameReady       bridgeFrameKind = 4
+	bridgeFrameMessage     bridgeFrameKind = 5
+	bridgeFrameUnsubscribe bridgeFrameKind = 6
+)
+
+type bridgeFrame struct {
+	kind     bridgeFrameKind
+	sender   Handle
+	receiver Handle
+	types    []uint32
+	payload  []byte
+}
+
+// Bridge connects a local broker with remote brokers. Every local member is announced to the
+// remote side, where it shows up as a proxy member; messages sent to a proxy are forwarded
+// over the link and injected into the remote broker with the original sender. Handles must be
+// unique across all bridged brokers and only local members are exported, so links are one hop.
+// Subscriptions by command type cross the link, predicate subscriptions stay local.
+type Bridge[Command ICommand] struct {
+	broker  *controller[Command]
+	codec   CommandCodec[Command]
+	factory *comm.TypedSocketFactory[*bridgeFrame]
+}
+
+type bridgeLink[Command ICommand] struct {
+	bridge  *Bridge[Command]
+	socket  comm.ITypedSocket[*bridgeFrame]
+	proxies map[Handle]*memberWrapper[Command]
+
+	mx       sync.Mutex
+	pending  []*bridgeFrame
+	isClosed bool
+	isReady  bool
+	chSignal chan bool
+	chReady  chan bool
+	chDone   chan error
+}
+
+func NewBridge[Command ICommand](b IBroker[Command], codec CommandCodec[Command]) *Bridge[Command] {
+	c, ok := b.(*controller[Command])
+	if !ok {
+		panic(fmt.Errorf("unable to bridge %T", b))
+	}
+	return &Bridge[Command]{
+		broker:  c,
+		codec:   codec,
+		factory: comm.NewTypedSocketFactory(readBridgeFrame, writeBridgeFrame),
+	}
+}
+
+// Attach runs the bridge protocol on an established connection and blocks until the link ends.
+// The connection is left open when the remote side disconnects.
+func (b *Bridge[Command]) Attach(conn comm.IAbstractSocket) error {
+	link := b.newLink(b.factory.New(conn))
+	err := <-link.chDone
+	if errors.Is(err, io.EOF) || errors.Is(err, ErrBridgeClosed) {
+		return nil
+	}
+	return err
+}
+
+// Connect dials a remote bridge and returns once the remote members are known locally.
+func (b *Bridge[Command]) Connect(addr string, port int) (io.Closer, error) {
+	socket, err := b.factory.NewTcpClient(addr, port)
+	if err != nil {
+		return nil, err
+	}
+	return b.connect(socket)
+}
+
+func (b *Bridge[Command]) Serve(addr string, errHandler func(err error)) error {
+	return comm.Serve(addr, func(conn net.Conn) error {
+		return b.Attach(conn)
+	}, errHandler)
+}
+
+func (b *Bridge[Command]) connect(socket comm.ITypedSocket[*bridgeFrame]) (io.Closer, error) {
+	link := b.newLink(socket)
+	select {
+	case <-link.chReady:
+		return link, nil
+	case err := <-link.chDone:
+		utils.IgnoreErr(socket.Close())
+		return nil, utils.Coalesce(err, ErrBridgeClosed)
+	}
+}
+
+func (b *Bridge[Command]) newLink(socket comm.ITypedSocket[*bridgeFrame]) *bridgeLink[Command] {
+	link := &bridgeLink[Command]{
+		bridge:   b,
+		socket:   socket,
+		proxies:  make(map[Handle]*memberWrapper[Command]),
+		chSignal: make(chan bool, 1),
+		chReady:  make(chan bool),
+		chDone:   make(chan error, 1),
+	}
+
+	// register the link and announce the local members in one step, so no change is lost
+	b.broker.p.ExecLocked(func() {
+		b.broker.links[link] = true
+		for id, m := range b.broker.members {
+			if m.link == nil {
+				link.memberJoined(id, b.broker.subscriptionsLocked(id))
+			}
+		}
+		link.enqueue(&bridgeFrame{kind: bridgeFrameReady})
+	})
+
+	go link.writeLoop()
+	go link.readLoop()
+	return link
+}
+
+func (l *bridgeLink[Command]) Close() error {
+	l.shutdown(ErrBridgeClosed, true)
+	return nil
+}
+
+func (l *bridgeLink[Command]) memberJoined(id Handle, types []uint32) {
+	l.enqueue(&bridgeFrame{kind: bridgeFrameJoin, sender: id, types: types})
+}
+
+func (l *bridgeLink[Command]) memberLeft(id Handle) {
+	l.enqueue(&bridgeFrame{kind: bridgeFrameLeave, sender: id})
+}
+
+func (l *bridgeLink[Command]) memberSubscribed(id Handle, types []uint32) {
+	l.enqueue(&bridgeFrame{kind: bridgeFrameSubscribe, sender: id, types: types})
+}
+
+func (l *bridgeLink[Command]) memberUnsubscribed(id Handle, types []uint32) {
+	l.enqueue(&bridgeFrame{kind: bridgeFrameUnsubscribe, sender: id, types: types})
+}
+
+func (l *bridgeLink[Command]) enqueue(frame *bridgeFrame) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	if l.isClosed {
+		return
+	}
+	l.pending = append(l.pending, frame)
+	select {
+	case l.chSignal <- true:
+	default:
+	}
+}
+
+func (l *bridgeLink[Command]) forward(receiver Handle) MemberMessageHandler[Command] {
+	return func(sender Handle, cmd Command, _ IMember[Command]) {
+		payload, err := l.bridge.codec.Encode(cmd)
+		if err != nil {
+			log.LogError("unable to encode bridged command: %v", err)
+			return
+		}
+		l.enqueue(&bridgeFrame{kind: bridgeFrameMessage, sender: sender, receiver: receiver, payload: payload})
+	}
+}
+
+func (l *bridgeLink[Command]) handleFrame(frame *bridgeFrame) error {
+	c := l.bridge.broker
+	switch frame.kind {
+	case bridgeFrameJoin:
+		var proxy *memberWrapper[Command]
+		c.p.ExecLocked(func() {
+			if c.members[frame.sender] != nil {
+				log.Warn("bridged member %v conflicts with a local member", frame.sender)
+				return
+			}
+			proxy = c.newMember(frame.sender, l.forward(frame.sender), l)
+			c.members[frame.sender] = proxy
+			l.proxies[frame.sender] = proxy
+		})
+		if proxy != nil && len(frame.types) > 0 {
+			proxy.Subscribe(frame.types...)
+		}
+	case bridgeFrameLeave:
+		if l.removeProxy(frame.sender) {
+			c.removeMember(frame.sender)
+		}
+	case bridgeFrameSubscribe:
+		if proxy := l.getProxy(frame.sender); proxy != nil {
+			proxy.Subscribe(frame.types...)
+		}
+	case bridgeFrameUnsubscribe:
+		if proxy := l.getProxy(frame.sender); proxy != nil {
+			proxy.Unsubscribe(frame.types...)
+		}
+	case bridgeFrameReady:
+		if !l.isReady {
+			l.isReady = true
+			close(l.chReady)
+		}
+	case bridgeFrameMessage:
+		cmd, err := l.bridge.codec.Decode(frame.payload)
+		if err != nil {
+			return err
+		}
+		return c.sendVia(l, frame.sender, frame.receiver, cmd, sendOptions{})
+	default:
+		return fmt.Errorf("%w: unknown kind %v", ErrBridgeFrame, frame.kind)
+	}
+	return nil
+}
+
+func (l *bridgeLink[Command]) getProxy(id Handle) *memberWrapper[Command] {
+	var proxy *memberWrapper[Command]
+	l.bridge.broker.p.ExecLocked(func() {
+		proxy = l.proxies[id]
+	})
+	return proxy
+}
+
+func (l *bridgeLink[Command]) removeProxy(id Handle) bool {
+	var ok bool
+	l.bridge.broker.p.ExecLocked(func() {
+		_, ok = l.proxies[id]
+		delete(l.proxies, id)
+	})
+	return ok
+}
+
+func (l *bridgeLink[Command]) readLoop() {
+	for {
+		frame, err := l.socket.Read()
+		if err == nil {
+			err = l.handleFrame(frame)
+		}
+		if err != nil {
+			l.shutdown(err, false)
+			return
+		}
+	}
+}
+
+func (l *bridgeLink[Command]) writeLoop() {
+	for range l.chSignal {
+		var (
+			frames   []*bridgeFrame
+			isClosed bool
+		)
+		utils.ExecLocked(&l.mx, func() {
+			frames, l.pending = l.pending, nil
+			isClosed = l.isClosed
+		})
+		if isClosed {
+			return
+		}
+		for _, frame := range frames {
+			if err := l.socket.Write(frame); err != nil {
+				l.shutdown(err, true)
+				return
+			}
+		}
+	}
+}
+
+func (l *bridgeLink[Command]) shutdown(err error, closeSocket bool) {
+	var isClosed bool
+	utils.ExecLocked(&l.mx, func() {
+		isClosed = l.isClosed
+		l.isClosed = true
+		l.pending = nil
+	})
+	if isClosed {
+		return
+	}
+
+	c := l.bridge.broker
+	var proxies []Handle
+	c.p.ExecLocked(func() {
+		delete(c.links, l)
+		proxies = utils.MapKeys(l.proxies, nil)
+		l.proxies = make(map[Handle]*memberWrapper[Command])
+	})
+	for _, id := range proxies {
+		c.removeMember(id)
+	}
+
+	if closeSocket {
+		utils.IgnoreErr(l.socket.Close())
+	}
+	close(l.chSignal)
+	l.chDone <- err
+}
+
+func readBridgeFrame(conn comm.IAbstractSocket) (*bridgeFrame, error) {
+	var (
+		frame       bridgeFrame
+		typeCount   uint32
+		payloadSize uint32
+	)
+	err := utils.FromReader(conn, &frame.kind, &frame.sender, &frame.receiver, &typeCount, &payloadSize)
+	if err != nil {
+		return nil, err
+	}
+	if typeCount > maxBridgeTypes || payloadSize > maxPayloadSize {
+		return nil, fmt.Errorf("%w: %v types, %v bytes", ErrBridgeFrame, typeCount, payloadSize)
+	}
+
+	frame.types = make([]uint32, typeCount)
+	frame.payload = make([]byte, payloadSize)
+	if typeCount > 0 {
+		if err := utils.FromReader(conn, frame.types); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := io.ReadFull(conn, frame.payload); err != nil {
+		return nil, err
+	}
+	return &frame, nil
+}
+
+func writeBridgeFrame(conn comm.IAbstractSocket, frame *bridgeFrame) error {
+	b := new(bytes.Buffer)
+	err := utils.WriteBytes(
+		b,
+		frame.kind, frame.sender, frame.receiver,
+		uint32(len(frame.types)), uint32(len(frame.payload)),
+		frame.types, frame.payload,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(b.Bytes())
+	return err
+}
@@ -0,0 +1,45 @@
+package broker
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/MrReality255/turbo-go/tg/utils"
+)
+
+func TestBridge(t *testing.T) {
+	var (
+		local  = New(testDescriptor, time.Second)
+		remote = New(testDescriptor, time.Second)
+		events = make(chan string, 1)
+	)
+	defer local.Close()
+	defer remote.Close()
+
+	client := local.AddMember(NewHandle(testTypeClient, 1), nil)
+	remote.AddMember(NewHandle(testTypeWorker, 1), echoHandler)
+	remote.AddMember(
+		NewHandle(testTypeClient, 2),
+		func(sender Handle, msg testCmd, member IMember[testCmd]) {
+			events <- fmt.Sprintf("%v %v", sender, msg.Payload)
+		},
+	).Subscribe(testTypeEvent)
+
+	connLocal, connRemote := net.Pipe()
+	go func() {
+		utils.IgnoreErr(NewBridge(remote, NewJSONCodec[testCmd]()).Attach(connRemote))
+	}()
+	bridge := NewBridge(local, NewJSONCodec[testCmd]())
+	link, err := bridge.connect(bridge.factory.New(connLocal))
+	utils.TestAsString(t, 1, "connect", "<nil>", err)
+
+	r, err := client.Request(NewHandle(testTypeWorker, 1), newTestCmd(testTypeWorker, "remote"))
+	utils.TestAsString(t, 2, "request", "echo: remote <nil>", fmt.Sprintf("%v %v", r.Payload, err))
+
+	client.Send(HandleAny, newTestCmd(testTypeEvent, "event"))
+	utils.TestAsString(t, 3, "subscription", fmt.Sprintf("%v event", NewHandle(testTypeClient, 1)), <-events)
+
+	utils.TestAsString(t, 4, "close", "<nil>", link.Close())
+}
@@ -1,27 +1,48 @@
 package broker
 
 import (
+	"cmp"
+	"context"
+	"slices"
+	"sync/atomic"
 	"time"
 
+	"github.com/MrReality255/turbo-go/tg/log"
 	"github.com/MrReality255/turbo-go/tg/utils"
 )
 
 const (
-	queueSize = 64
+	queueSize = 1024
 )
 
 type RequestHandler[Cmd ICommand] func(cmd Cmd, err error) bool
 type MemberMessageHandler[Cmd ICommand] func(sender Handle, msg Cmd, member IMember[Cmd])
 
 type IBroker[Cmd ICommand] interface {
-	AddMember(id Handle, messageHandler MemberMessageHandler[Cmd]) IMember[Cmd]
+	AddMember(id Handle, messageHandler MemberMessageHandler[Cmd], options ...MemberOption[Cmd]) IMember[Cmd]
+	AddNamedMember(
+		typeID uint32, name string, messageHandler MemberMessageHandler[Cmd], options ...MemberOption[Cmd],
+	) (IMember[Cmd], error)
+	LookupName(name string) (Handle, bool)
 	Close()
+	Shutdown(ctx context.Context) error
+	ListMembers(typeID uint32) []Handle
+	Replay() error
+	Stats() Stats
 }
 
+type Option[Cmd ICommand] func(c *controller[Cmd])
+type MemberOption[Cmd ICommand] func(m *memberWrapper[Cmd])
+
 type messageWrapper[Command ICommand] struct {
 	cmd      Command
 	sender   Handle
 	receiver Handle
+	via      *bridgeLink[Command]
+	seqID    uint64
+	overflow OverflowPolicy
+	priority Priority
+	span     *traceSpan
 }
 
 type subscribersMap map[uint32]map[Handle]bool
@@ -30,35 +51,102 @@ type controller[Command ICommand] struct {
 	requestTimeout time.Duration
 	descriptor     CommandDescriptor[Command]
 
-	// mx sync.Mutex
+	queueSize      int
+	mailboxSize    int
+	mailboxWorkers int
 
-	chQueue     chan *messageWrapper[Command]
+	queue       *mailbox[*messageWrapper[Command]]
 	members     map[Handle]*memberWrapper[Command]
 	subscribers subscribersMap
+	filters     map[Handle]filterList[Command]
+	topics      map[string]*topicSubscription
+	names       map[string]Handle
+	lastSeqIDs  map[uint32]uint32
+	links       map[*bridgeLink[Command]]bool
+	store       IMessageStore[Command]
+	balancer    IBalancer[Command]
+	clock       IClock
+	timers      *timerQueue
+	sim         *Simulation
+	exporter    ISpanExporter
+	dedup       *deduplicator
+	counters    counters
 	p           utils.IRunner
+	pending     atomic.Int64
+	isClosing   atomic.Bool
+	chClosing   chan bool
+	tasks       taskGroup
+
+	deadLetterSink Handle
+	wrapDeadLetter func(dl DeadLetter[Command]) Command
+
+	eventSource     Handle
+	wrapMemberEvent func(ev MemberEvent) Command
+
+	supervisor  Handle
+	wrapFailure func(f Failure[Command]) Command
+
+	dispatchInterceptors []Interceptor[Command]
+	handleInterceptors   []Interceptor[Command]
 }
 
 func New[Command ICommand](
 	descriptor CommandDescriptor[Command],
 	timeout time.Duration,
+	options ...Option[Command],
 ) IBroker[Command] {
 	c := &controller[Command]{
 		descriptor:     descriptor,
 		requestTimeout: timeout,
-		chQueue:        make(chan *messageWrapper[Command], queueSize),
+		queueSize:      queueSize,
+		mailboxSize:    mailboxSize,
+		mailboxWorkers: mailboxWorkers,
 		members:        make(map[Handle]*memberWrapper[Command]),
 		subscribers:    make(subscribersMap),
+		filters:        make(map[Handle]filterList[Command]),
+		topics:         make(map[string]*topicSubscription),
+		names:          make(map[string]Handle),
+		lastSeqIDs:     make(map[uint32]uint32),
+		links:          make(map[*bridgeLink[Command]]bool),
+		balancer:       NewRoundRobinBalancer[Command](),
+		chClosing:      make(chan bool),
 	}
-	c.p = utils.NewRunner(
-		func() (canContinue bool) {
-			msg, ok := <-c.chQueue
+	for _, option := range options {
+		option(c)
+	}
+	if c.sim != nil {
+		c.clock = c.sim.clock
+	} else {
+		c.timers = newTimerQueue(c.spawn)
+		c.clock = c.timers
+	}
+	if c.wrapDeadLetter != nil {
+		c.members[c.deadLetterSink] = c.newMember(c.deadLetterSink, nil, nil)
+	}
+	if c.wrapMemberEvent != nil {
+		c.members[c.eventSource] = c.newMember(c.eventSource, nil, nil)
+	}
+	c.queue = newLaneMailbox[*messageWrapper[Command]](c.queueSize, priorityLanes, ErrBrokerClosed)
+	var onNext func() bool
+	if c.sim == nil {
+		onNext = func() (canContinue bool) {
+			msg, ok := c.queue.pop()
 			if ok {
-				c.dispatchMessage(msg)
+				c.processQueued(msg)
 			}
 			return ok
-		},
+		}
+	}
+	c.p = utils.NewRunner(
+		onNext,
 		func() error {
-			close(c.chQueue)
+			if c.timers != nil {
+				c.timers.close()
+			}
+			c.queue.close()
+			if c.store != nil {
+				return c.store.Close()
+			}
 			return nil
 		},
 	)
@@ -67,78 +155,332 @@ func New[Command ICommand](
 	return c
 }
 
+func WithQueueSize[Command ICommand](size int) Option[Command] {
+	return func(c *controller[Command]) {
+		c.queueSize = size
+	}
+}
+
+// WithMailbox sets the default mailbox size and the number of workers of every member
+func WithMailbox[Command ICommand](size int, workers int) Option[Command] {
+	return func(c *controller[Command]) {
+		c.mailboxSize = size
+		c.mailboxWorkers = workers
+	}
+}
+
+func WithMemberMailbox[Command ICommand](size int, workers int) MemberOption[Command] {
+	return func(m *memberWrapper[Command]) {
+		m.mailboxSize = size
+		m.mailboxWorkers = workers
+	}
+}
+
 func (c *controller[Command]) AddMember(
-	handle Handle, messageHandler MemberMessageHandler[Command],
+	handle Handle, messageHandler MemberMessageHandler[Command], options ...MemberOption[Command],
 ) IMember[Command] {
+	// a member with the same handle is closed, like by IMember.Close
+	if c.removeMember(handle) {
+		log.Warn("member %v replaces an existing member", handle)
+	}
+
+	defer c.memberEvent(MemberJoined, handle)
 	return utils.CallWith(c.p.ExecLocked, func() IMember[Command] {
-		wrapper := &memberWrapper[Command]{
-			id:             handle,
-			descriptor:     c.descriptor,
-			messageHandler: messageHandler,
-			broker:         c,
-			requestTimeout: c.requestTimeout,
-			reqManager:     make(map[Handle]IRequestManager[Command]),
-		}
-		c.members[handle] = wrapper
-		return wrapper
+		return c.addMemberLocked(handle, messageHandler, options...)
 	})
 }
 
+func (c *controller[Command]) addMemberLocked(
+	handle Handle, messageHandler MemberMessageHandler[Command], options ...MemberOption[Command],
+) *memberWrapper[Command] {
+	wrapper := c.newMember(handle, messageHandler, nil, options...)
+	c.members[handle] = wrapper
+	for link := range c.links {
+		link.memberJoined(handle, nil)
+	}
+	return wrapper
+}
+
+// Close is a Shutdown that processes the queued messages for at most a few seconds
 func (c *controller[Command]) Close() {
-	utils.IgnoreErr(c.p.Close())
+	ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+	utils.IgnoreErr(c.Shutdown(ctx))
 }
 
-func (c *controller[Command]) dispatchMessage(msg *messageWrapper[Command]) {
+func (c *controller[Command]) dispatchMessage(msg *messageWrapper[Command]) bool {
+	var (
+		isDispatched bool
+		receivers    []*memberWrapper[Command]
+		reason       DeadLetterReason
+	)
 	c.p.ExecLocked(func() {
-		// the message has an exact receiver: pass it to the receiver
-		if msg.receiver.GetSeqID() != HandleAny {
-			go c.members[msg.receiver].handleMessage(msg.sender, msg.cmd)
-			return
+		isDispatched = true
+		receivers, reason = c.getReceiversLocked(msg)
+	})
+	if reason != 0 {
+		c.deadLetter(msg, reason)
+	}
+
+	// the mailboxes are filled outside the lock; a full mailbox parks the message in its bounded
+	// backlog, so a slow member never holds up the delivery to the others
+	for _, m := range receivers {
+		utils.IgnoreErr(m.handleMessage(msg.forDelivery(len(receivers))))
+	}
+	return isDispatched
+}
+
+func (c *controller[Command]) getReceiversLocked(
+	msg *messageWrapper[Command],
+) ([]*memberWrapper[Command], DeadLetterReason) {
+	// the message has an exact receiver: pass it to the receiver
+	if msg.receiver.GetSeqID() != HandleAny {
+		switch m := c.members[msg.receiver]; {
+		case m == nil:
+			return nil, DeadLetterUnknownReceiver
+		case m.accepts(msg):
+			return []*memberWrapper[Command]{m}, 0
+		default:
+			return nil, 0
 		}
+	}
 
-		// pass the message to everyone who is subscribed to the message type
-		var (
-			msgType     = c.descriptor.GetID(msg.cmd).GetTypeID()
-			handled     = make(map[Handle]bool)
-			handledType = make(map[uint32]bool)
-		)
-
-		for _, subID := range []uint32{msgType, 0} {
-			for subscriber, ok := range c.subscribers[subID] {
-				if !ok || handled[subscriber] {
-					continue
-				}
-				handled[subscriber] = true
-				handledType[subscriber.GetTypeID()] = true
-				go c.members[subscriber].handleMessage(msg.sender, msg.cmd)
+	// pass the message to everyone who is subscribed to the message type
+	var (
+		msgType     = c.descriptor.GetID(msg.cmd).GetTypeID()
+		handled     = make(map[Handle]bool)
+		handledType = make(map[uint32]bool)
+		result      []*memberWrapper[Command]
+		hasMatch    bool
+	)
+
+	for _, subID := range []uint32{msgType, 0} {
+		for subscriber, ok := range c.subscribers[subID] {
+			hasMatch = hasMatch || ok
+			if !ok || handled[subscriber] || !c.members[subscriber].accepts(msg) {
+				continue
 			}
+			handled[subscriber] = true
+			handledType[subscriber.GetTypeID()] = true
+			result = append(result, c.members[subscriber])
 		}
+	}
 
-		// the message has receiver type: send it to one receiver of this type
-		if recTypeID := msg.receiver.GetTypeID(); recTypeID != 0 && !handledType[recTypeID] {
-			for m, h := range c.members {
-				if m.GetTypeID() == recTypeID {
-					go h.handleMessage(msg.sender, msg.cmd)
-					return
-				}
-			}
+	// topic subscriptions
+	for _, subscriber := range c.topicSubscribersLocked(msg.cmd) {
+		hasMatch = true
+		if handled[subscriber] || !c.members[subscriber].accepts(msg) {
+			continue
+		}
+		handled[subscriber] = true
+		handledType[subscriber.GetTypeID()] = true
+		result = append(result, c.members[subscriber])
+	}
+
+	// predicate subscriptions
+	for subscriber, filters := range c.filters {
+		if handled[subscriber] || !c.members[subscriber].accepts(msg) || !filters.match(msg.sender, msg.cmd) {
+			continue
 		}
+		hasMatch = true
+		handled[subscriber] = true
+		handledType[subscriber.GetTypeID()] = true
+		result = append(result, c.members[subscriber])
+	}
+
+	// the subscribers are kept in maps, the sorted order makes the delivery reproducible
+	slices.SortFunc(result, func(a, b *memberWrapper[Command]) int {
+		return cmp.Compare(a.id, b.id)
 	})
+
+	// the message has receiver type: send it to one receiver of this type
+	recTypeID := msg.receiver.GetTypeID()
+	if recTypeID != 0 && !handledType[recTypeID] {
+		var m *memberWrapper[Command]
+		if m, hasMatch = c.pickMemberLocked(msg, recTypeID); m != nil {
+			return append(result, m), 0
+		}
+	}
+
+	switch {
+	case hasMatch:
+		return result, 0
+	case recTypeID != 0:
+		return result, DeadLetterUnknownReceiver
+	default:
+		return result, DeadLetterNoSubscriber
+	}
+}
+
+func (c *controller[Command]) newMember(
+	handle Handle,
+	messageHandler MemberMessageHandler[Command],
+	link *bridgeLink[Command],
+	options ...MemberOption[Command],
+) *memberWrapper[Command] {
+	m := &memberWrapper[Command]{
+		id:             handle,
+		descriptor:     c.descriptor,
+		messageHandler: messageHandler,
+		broker:         c,
+		link:           link,
+		requestTimeout: c.requestTimeout,
+		mailboxSize:    c.mailboxSize,
+		mailboxWorkers: c.mailboxWorkers,
+		reqManager:     make(map[Handle]*requestManager[Command]),
+	}
+	for _, option := range options {
+		option(m)
+	}
+	m.start()
+	return m
 }
 
-func (c *controller[Command]) removeMember(id Handle) {
+// removeMember closes the member and aborts its requests, it reports whether it was found
+func (c *controller[Command]) removeMember(id Handle) bool {
+	var (
+		removed *memberWrapper[Command]
+		pending []*messageWrapper[Command]
+		isLocal bool
+	)
 	c.p.ExecLocked(func() {
+		m := c.members[id]
+		if m == nil {
+			return
+		}
+		removed = m
 		delete(c.members, id)
+		pending = m.mailbox.close()
 		// remove all subscriptions
 		for _, m := range c.subscribers {
 			delete(m, id)
 		}
+		delete(c.filters, id)
+		for pattern := range c.topics {
+			c.removeTopicLocked(pattern, id)
+		}
+		if m.name != "" {
+			delete(c.names, m.name)
+		}
+		isLocal = m.link == nil
+		if isLocal {
+			for link := range c.links {
+				link.memberLeft(id)
+			}
+		}
 	})
+	if removed == nil {
+		return false
+	}
+
+	removed.abortRequests()
+	for _, msg := range pending {
+		c.deadLetter(msg, DeadLetterMemberClosed)
+	}
+	if isLocal {
+		c.memberEvent(MemberLeft, id)
+	}
+	return true
+}
+
+func (c *controller[Command]) send(sender Handle, receiver Handle, cmd Command, options sendOptions) error {
+	return c.sendVia(nil, sender, receiver, cmd, options)
+}
+
+func (c *controller[Command]) sendVia(
+	link *bridgeLink[Command], sender Handle, receiver Handle, cmd Command, options sendOptions,
+) error {
+	// while the broker drains, only the responses to pending requests are accepted
+	if c.isClosing.Load() && !c.isResponse(sender, receiver, cmd) {
+		return ErrBrokerClosed
+	}
+	if options.overflow == OverflowBlock {
+		c.waitForReceiver(sender, receiver, cmd)
+	}
+	msg := &messageWrapper[Command]{
+		cmd:      cmd,
+		sender:   sender,
+		receiver: receiver,
+		via:      link,
+		overflow: options.overflow,
+		priority: c.getPriority(cmd, options),
+		span:     options.span,
+	}
+	if msg.span == nil {
+		msg.span = c.newSpan(options.parent, false)
+	}
+	if c.store != nil {
+		// a message that can't be persisted is not sent at all
+		seqID, err := c.store.Append(sender, receiver, cmd)
+		if err != nil {
+			return err
+		}
+		msg.seqID = seqID
+	}
+	err := c.enqueue(msg)
+	if err != nil {
+		c.removeStored(msg)
+	}
+	return err
 }
-func (c *controller[Command]) send(sender Handle, receiver Handle, cmd Command) {
+
+// waitForReceiver applies the backpressure of a full mailbox to the sender of a message with an
+// exact receiver. Responses and messages to the sender itself never wait, and neither does the
+// simulation.
+func (c *controller[Command]) waitForReceiver(sender Handle, receiver Handle, cmd Command) {
+	if c.sim != nil || receiver.GetSeqID() == HandleAny || receiver == sender {
+		return
+	}
+	var m *memberWrapper[Command]
 	c.p.ExecLocked(func() {
-		c.chQueue <- &messageWrapper[Command]{cmd: cmd, sender: sender, receiver: receiver}
+		m = c.members[receiver]
 	})
+	if m == nil || m.expectsResponse(sender, cmd) {
+		return
+	}
+	m.mailbox.waitRoom()
+}
+
+func (c *controller[Command]) enqueue(msg *messageWrapper[Command]) error {
+	c.pending.Add(1)
+	dropped, err := c.queue.pushLane(msg, msg.priority.lane(), msg.overflow)
+	for _, msg := range dropped {
+		c.pending.Add(-1)
+		c.removeStored(msg)
+		c.deadLetter(msg, DeadLetterOverflow)
+	}
+	if err != nil {
+		c.pending.Add(-1)
+		return err
+	}
+	if c.sim != nil {
+		c.sim.post(func() {
+			if msg, ok := c.queue.tryPop(); ok {
+				c.processQueued(msg)
+			}
+		})
+	}
+	return nil
+}
+
+func (c *controller[Command]) processQueued(msg *messageWrapper[Command]) {
+	if c.processMessage(msg) {
+		c.removeStored(msg)
+	}
+	c.pending.Add(-1)
+}
+
+// spawn runs fct on its own goroutine, or as a step of the simulation; Shutdown waits for it
+func (c *controller[Command]) spawn(fct func()) {
+	if c.sim != nil {
+		c.sim.post(fct)
+		return
+	}
+	c.tasks.add()
+	go func() {
+		defer c.tasks.done()
+		fct()
+	}()
 }
 
 func (c *controller[Command]) subscribe(subscriber Handle, cmdTypes ...uint32) {
@@ -149,5 +491,38 @@ func (c *controller[Command]) subscribe(subscriber Handle, cmdTypes ...uint32) {
 			}
 			c.subscribers[cmdType][subscriber] = true
 		}
+		if m := c.members[subscriber]; m != nil && m.link == nil {
+			for link := range c.links {
+				link.memberSubscribed(subscriber, cmdTypes)
+			}
+		}
 	})
 }
+
+func (c *controller[Command]) unsubscribe(subscriber Handle, cmdTypes ...uint32) {
+	c.p.ExecLocked(func() {
+		for _, cmdType := range cmdTypes {
+			delete(c.subscribers[cmdType], subscriber)
+			if len(c.subscribers[cmdType]) == 0 {
+				delete(c.subscribers, cmdType)
+			}
+		}
+		if m := c.members[subscriber]; m != nil && m.link == nil {
+			for link := range c.links {
+				link.memberUnsubscribed(subscriber, cmdTypes)
+			}
+		}
+	})
+}
+
+func (c *controller[Command]) subscriptionsLocked(id Handle) []uint32 {
+	return utils.MapKeysIf(
+		c.subscribers,
+		func(_ uint32, m map[Handle]bool) bool {
+			return m[id]
+		},
+		func(item1 uint32, item2 uint32) bool {
+			return item1 < item2
+		},
+	)
+}
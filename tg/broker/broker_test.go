@@ -1,43 +1,147 @@
 package broker
 
-/*
-type testMessage struct {
-	id      uint64
-	refID   uint64
-	payload string
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/MrReality255/turbo-go/tg/utils"
+)
+
+const (
+	testTypeClient = 1
+	testTypeWorker = 2
+	testTypeEvent  = 10
+	testTypeReply  = 11
+)
+
+type testCmd struct {
+	ID      Handle
+	Ref     Handle
+	Payload string
 }
 
-type testSender struct{}
+var (
+	testSeq        atomic.Uint32
+	testDescriptor = CommandDescriptor[testCmd]{
+		GetID: func(cmd testCmd) Handle {
+			return cmd.ID
+		},
+		GetRef: func(cmd testCmd) Handle {
+			return cmd.Ref
+		},
+	}
+)
+
+func newTestCmd(typeID uint32, payload string) testCmd {
+	return testCmd{ID: NewHandle(typeID, testSeq.Add(1)), Payload: payload}
+}
 
-func (t testMessage) GetID() Handle {
-	return Handle(t.id)
+func (c testCmd) reply(payload string) testCmd {
+	r := newTestCmd(testTypeReply, payload)
+	r.Ref = c.ID
+	return r
 }
 
-func (t testMessage) GetRefID() Handle {
-	return Handle(t.refID)
+func echoHandler(sender Handle, msg testCmd, member IMember[testCmd]) {
+	member.Send(sender, msg.reply("echo: "+msg.Payload))
 }
 
 func TestBroker(t *testing.T) {
-	b := New[testMessage](time.Second)
-	m := b.AddMember(
-		1,
-		func(sender Handle, msg testMessage, member IMember[testMessage]) {
-			time.Sleep(time.Millisecond)
-			response := testMessage{
-				id:      msg.id + 1,
-				refID:   msg.id,
-				payload: fmt.Sprintf("response: %v", msg.payload),
-			}
-			member.Send(sender, response)
+	b := New(testDescriptor, time.Second)
+	defer b.Close()
 
-		},
+	var (
+		client   = b.AddMember(NewHandle(testTypeClient, 1), nil)
+		events   = make(chan string, 1)
+		listener = b.AddMember(
+			NewHandle(testTypeClient, 2),
+			func(sender Handle, msg testCmd, member IMember[testCmd]) {
+				events <- fmt.Sprintf("%v %v", sender, msg.Payload)
+			},
+		)
+	)
+	b.AddMember(NewHandle(testTypeWorker, 1), echoHandler)
+	listener.Subscribe(testTypeEvent)
+
+	r, err := client.Request(NewHandle(testTypeWorker, 1), newTestCmd(testTypeWorker, "direct"))
+	utils.TestAsString(t, 1, "request", "echo: direct <nil>", fmt.Sprintf("%v %v", r.Payload, err))
+
+	client.Send(HandleAny, newTestCmd(testTypeEvent, "event"))
+	utils.TestAsString(t, 2, "subscription", fmt.Sprintf("%v event", NewHandle(testTypeClient, 1)), <-events)
+}
+
+func TestRequestStream(t *testing.T) {
+	var (
+		b        = New(testDescriptor, time.Second)
+		workerID = NewHandle(testTypeWorker, 1)
+		client   = b.AddMember(NewHandle(testTypeClient, 1), nil)
 	)
+	defer b.Close()
+
+	b.AddMember(workerID, func(sender Handle, msg testCmd, member IMember[testCmd]) {
+		for i := range 3 {
+			member.Send(sender, msg.reply(fmt.Sprintf("part %v", i)))
+		}
+	})
+
+	l := utils.NewStringList(16, false)
+	for cmd, err := range client.RequestStream(workerID, newTestCmd(testTypeWorker, "stream")) {
+		l.Addf("%v %v", cmd.Payload, err)
+		if len(l.Content()) == 2 {
+			break
+		}
+	}
+	utils.TestAsString(t, 1, "responses", "[part 0 <nil> part 1 <nil>]", l.Content())
+
+	// leaving the loop ends the request
+	waitUntil(t, "request ended", func() bool {
+		return len(b.Stats().Requests) == 0
+	})
+
+	for _, err := range client.RequestStream(NewHandle(testTypeWorker, 2), newTestCmd(testTypeWorker, "unknown")) {
+		utils.TestAsString(t, 3, "unknown", ErrUnknownReceiver.Error(), err)
+	}
 }
 
-func (t *testSender) GetID() Handle {
-	return 1
+func TestRequestStreamEnd(t *testing.T) {
+	descriptor := testDescriptor
+	descriptor.IsLast = func(cmd testCmd) bool {
+		return cmd.Payload == "last"
+	}
+	var (
+		b        = New(descriptor, time.Minute)
+		workerID = NewHandle(testTypeWorker, 1)
+		client   = b.AddMember(NewHandle(testTypeClient, 1), nil)
+	)
+	defer b.Close()
+
+	b.AddMember(workerID, func(sender Handle, msg testCmd, member IMember[testCmd]) {
+		for _, payload := range []string{"first", "last"} {
+			utils.MustSucceed(member.Send(sender, msg.reply(payload)))
+		}
+	})
+
+	// the stream ends with the last response instead of the timeout
+	l := utils.NewStringList(16, false)
+	for cmd, err := range client.RequestStream(workerID, newTestCmd(testTypeWorker, "stream")) {
+		l.Addf("%v %v", cmd.Payload, err)
+	}
+	utils.TestAsString(t, 1, "responses", "[first <nil> last <nil>]", l.Content())
+	waitUntil(t, "request ended", func() bool {
+		return len(b.Stats().Requests) == 0
+	})
 }
 
-func (t *testSender) HandleMessage(sender Handle, msg testMessage) {
+// waitUntil polls the condition until it holds, the test fails if it doesn't within a few seconds
+func waitUntil(t *testing.T, hint string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for: %v", hint)
+		}
+		time.Sleep(time.Millisecond)
+	}
 }
-*/
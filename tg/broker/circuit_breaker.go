@@ -0,0 +1,195 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	ErrCircuitOpen = errors.New("circuit breaker is open")
+)
+
+type CircuitState uint8
+
+const (
+	CircuitClosed   CircuitState = 0
+	CircuitOpen     CircuitState = 1
+	CircuitHalfOpen CircuitState = 2
+)
+
+// CircuitBreakerConfig opens the circuit of a receiver once at least MinRequests of the last
+// Window requests finished and FailureRate of them failed, at least one. FailureRate is in
+// (0, 1] and Window is positive. After OpenDuration a single probe request is let through; its
+// success closes the circuit again.
+type CircuitBreakerConfig struct {
+	Window       int
+	MinRequests  int
+	FailureRate  float64
+	OpenDuration time.Duration
+}
+
+type circuitBreaker struct {
+	mx       sync.Mutex
+	config   CircuitBreakerConfig
+	state    CircuitState
+	results  []bool
+	next     int
+	openedAt time.Time
+	isProbe  bool
+}
+
+// WithCircuitBreaker adds a circuit breaker for every receiver the member sends requests to
+func WithCircuitBreaker[Command ICommand](config CircuitBreakerConfig) MemberOption[Command] {
+	if config.Window <= 0 || config.FailureRate <= 0 || config.FailureRate > 1 {
+		panic("circuit breaker requires a window and a failure rate in (0, 1]")
+	}
+	return func(m *memberWrapper[Command]) {
+		m.breakerConfig = &config
+	}
+}
+
+func (m *memberWrapper[Command]) CircuitState(receiver Handle) CircuitState {
+	if cb := m.getBreaker(receiver); cb != nil {
+		return cb.getState(m.broker.clock.Now())
+	}
+	return CircuitClosed
+}
+
+// guardRequest fails the request if the circuit of the receiver is open, otherwise it records
+// the first response or the error of the request before the handler sees it
+func (m *memberWrapper[Command]) guardRequest(
+	receiver Handle, handler RequestHandler[Command],
+) (RequestHandler[Command], bool) {
+	cb := m.getBreaker(receiver)
+	if cb == nil {
+		return handler, true
+	}
+	if !cb.allow(m.broker.clock.Now()) {
+		m.broker.spawn(func() {
+			var dummy Command
+			_ = handler(dummy, ErrCircuitOpen)
+		})
+		return handler, false
+	}
+
+	var once sync.Once
+	return func(responseCmd Command, err error) bool {
+		once.Do(func() {
+			cb.record(m.broker.clock.Now(), err)
+		})
+		return handler(responseCmd, err)
+	}, true
+}
+
+func (m *memberWrapper[Command]) getBreaker(receiver Handle) *circuitBreaker {
+	if m.breakerConfig == nil {
+		return nil
+	}
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	if m.breakers == nil {
+		m.breakers = make(map[Handle]*circuitBreaker)
+	}
+	if m.breakers[receiver] == nil {
+		m.breakers[receiver] = &circuitBreaker{
+			config:  *m.breakerConfig,
+			results: make([]bool, 0, m.breakerConfig.Window),
+		}
+	}
+	return m.breakers[receiver]
+}
+
+func (cb *circuitBreaker) allow(now time.Time) bool {
+	cb.mx.Lock()
+	defer cb.mx.Unlock()
+
+	switch cb.getStateLocked(now) {
+	case CircuitClosed:
+		return true
+	case CircuitHalfOpen:
+		if cb.isProbe {
+			return false
+		}
+		cb.state = CircuitHalfOpen
+		cb.isProbe = true
+		return true
+	default:
+		return false
+	}
+}
+
+func (cb *circuitBreaker) record(now time.Time, err error) {
+	// cancelled and aborted requests say nothing about the receiver
+	if errors.Is(err, context.Canceled) || errors.Is(err, ErrRequestAborted) {
+		cb.mx.Lock()
+		cb.isProbe = false
+		cb.mx.Unlock()
+		return
+	}
+
+	cb.mx.Lock()
+	defer cb.mx.Unlock()
+
+	if cb.state == CircuitHalfOpen {
+		cb.isProbe = false
+		if err != nil {
+			cb.state, cb.openedAt = CircuitOpen, now
+			return
+		}
+		cb.state = CircuitClosed
+		cb.results, cb.next = cb.results[:0], 0
+		return
+	}
+	if cb.state != CircuitClosed {
+		return
+	}
+
+	if len(cb.results) < cap(cb.results) {
+		cb.results = append(cb.results, err != nil)
+	} else {
+		cb.results[cb.next] = err != nil
+		cb.next = (cb.next + 1) % len(cb.results)
+	}
+
+	failures := 0
+	for _, failed := range cb.results {
+		if failed {
+			failures++
+		}
+	}
+	if failures > 0 && len(cb.results) >= cb.config.MinRequests &&
+		float64(failures) >= cb.config.FailureRate*float64(len(cb.results)) {
+		cb.state, cb.openedAt = CircuitOpen, now
+		cb.results, cb.next = cb.results[:0], 0
+	}
+}
+
+func (cb *circuitBreaker) getState(now time.Time) CircuitState {
+	cb.mx.Lock()
+	defer cb.mx.Unlock()
+	return cb.getStateLocked(now)
+}
+
+// getStateLocked reports an open circuit as half-open once the open duration has passed
+func (cb *circuitBreaker) getStateLocked(now time.Time) CircuitState {
+	if cb.state == CircuitOpen && !now.Before(cb.openedAt.Add(cb.config.OpenDuration)) {
+		return CircuitHalfOpen
+	}
+	return cb.state
+}
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("unknown: %v", int(s))
+	}
+}
@@ -0,0 +1,86 @@
+package broker
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/MrReality255/turbo-go/tg/utils"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var (
+		sim      = NewSimulation(1, time.Unix(0, 0))
+		b        = New(testDescriptor, 20*time.Millisecond, WithSimulation[testCmd](sim))
+		workerID = NewHandle(testTypeWorker, 1)
+		config   = CircuitBreakerConfig{Window: 4, MinRequests: 2, FailureRate: 0.5, OpenDuration: 50 * time.Millisecond}
+		client   = b.AddMember(NewHandle(testTypeClient, 1), nil, WithCircuitBreaker[testCmd](config))
+		results  = utils.NewStringList(16, false)
+	)
+	defer b.Close()
+
+	// requests with the payload "lost" are never answered
+	b.AddMember(workerID, func(sender Handle, msg testCmd, member IMember[testCmd]) {
+		if msg.Payload != "lost" {
+			echoHandler(sender, msg, member)
+		}
+	})
+	request := func(payload string) {
+		client.RequestMultiple(workerID, newTestCmd(testTypeWorker, payload), func(cmd testCmd, err error) bool {
+			results.Addf("%v %v %v", cmd.Payload, err, client.CircuitState(workerID))
+			return true
+		})
+	}
+
+	request("lost")
+	request("lost")
+	sim.Advance(20 * time.Millisecond)
+	utils.TestAsString(t, 1, "opened", fmt.Sprintf("[ %v closed  %v open]", ErrRequestTimeout, ErrRequestTimeout), results.Content())
+
+	request("rejected")
+	sim.Run()
+	utils.TestAsString(t, 2, "fail fast", fmt.Sprintf(" %v open", ErrCircuitOpen), results.Content()[2])
+	utils.TestAsString(t, 3, "other receiver", "closed", client.CircuitState(NewHandle(testTypeWorker, 2)))
+
+	sim.Advance(config.OpenDuration)
+	utils.TestAsString(t, 4, "half-open", "half-open", client.CircuitState(workerID))
+	request("lost")
+	sim.Advance(20 * time.Millisecond)
+	utils.TestAsString(t, 5, "failed probe", fmt.Sprintf(" %v open", ErrRequestTimeout), results.Content()[3])
+
+	sim.Advance(config.OpenDuration)
+	request("probe")
+	sim.Run()
+	utils.TestAsString(t, 6, "closed", "echo: probe <nil> closed", results.Content()[4])
+}
+
+func TestCircuitBreakerSuccess(t *testing.T) {
+	var (
+		sim      = NewSimulation(1, time.Unix(0, 0))
+		b        = New(testDescriptor, time.Second, WithSimulation[testCmd](sim))
+		workerID = NewHandle(testTypeWorker, 1)
+		config   = CircuitBreakerConfig{Window: 10, FailureRate: 0.1, OpenDuration: time.Minute}
+		client   = b.AddMember(NewHandle(testTypeClient, 1), nil, WithCircuitBreaker[testCmd](config))
+		results  = utils.NewStringList(16, false)
+	)
+	defer b.Close()
+
+	// successful requests never open the circuit, even without a minimum of requests
+	b.AddMember(workerID, echoHandler)
+	for range 2 {
+		client.RequestMultiple(workerID, newTestCmd(testTypeWorker, "ok"), func(cmd testCmd, err error) bool {
+			results.Addf("%v %v", cmd.Payload, err)
+			return true
+		})
+		sim.Run()
+	}
+	utils.TestAsString(t, 1, "responses", "[echo: ok <nil> echo: ok <nil>]", results.Content())
+	utils.TestAsString(t, 2, "state", "closed", client.CircuitState(workerID))
+}
+
+func TestCircuitBreakerInvalid(t *testing.T) {
+	defer func() {
+		utils.TestAsString(t, 1, "invalid", "circuit breaker requires a window and a failure rate in (0, 1]", recover())
+	}()
+	WithCircuitBreaker[testCmd](CircuitBreakerConfig{Window: 10, OpenDuration: time.Minute})
+}
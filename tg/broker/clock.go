@@ -0,0 +1,90 @@
+package broker
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// IClock provides the time for request timeouts and scheduled messages
+type IClock interface {
+	Now() time.Time
+	// AfterFunc calls fct once the duration has passed; stop reports whether the call was
+	// still pending
+	AfterFunc(d time.Duration, fct func()) (stop func() bool)
+}
+
+type systemClock struct{}
+
+// VirtualClock only moves forward when it is advanced, the due functions run on the caller
+type VirtualClock struct {
+	mx      sync.Mutex
+	now     time.Time
+	entries timerHeap
+}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) AfterFunc(d time.Duration, fct func()) func() bool {
+	return time.AfterFunc(d, fct).Stop
+}
+
+func NewVirtualClock(start time.Time) *VirtualClock {
+	return &VirtualClock{now: start}
+}
+
+func (c *VirtualClock) Now() time.Time {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.now
+}
+
+func (c *VirtualClock) AfterFunc(d time.Duration, fct func()) func() bool {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	entry := &timerEntry{at: c.now.Add(d), fct: fct}
+	heap.Push(&c.entries, entry)
+	return func() bool {
+		c.mx.Lock()
+		defer c.mx.Unlock()
+		if entry.index < 0 {
+			return false
+		}
+		heap.Remove(&c.entries, entry.index)
+		return true
+	}
+}
+
+// Advance moves the clock forward and calls the due functions in the order of their time
+func (c *VirtualClock) Advance(d time.Duration) {
+	target := c.Now().Add(d)
+	for {
+		entry := c.next(target)
+		if entry == nil {
+			break
+		}
+		entry.fct()
+	}
+}
+
+// next removes the first entry due until target and moves the clock to its time; without
+// such an entry the clock moves to target
+func (c *VirtualClock) next(target time.Time) *timerEntry {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	if len(c.entries) == 0 || c.entries[0].at.After(target) {
+		if target.After(c.now) {
+			c.now = target
+		}
+		return nil
+	}
+	entry := heap.Pop(&c.entries).(*timerEntry)
+	if entry.at.After(c.now) {
+		c.now = entry.at
+	}
+	return entry
+}
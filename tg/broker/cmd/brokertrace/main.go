@@ -0,0 +1,39 @@
+// brokertrace prints the call trees of the spans recorded by broker.NewJSONLinesExporter
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/MrReality255/turbo-go/tg/broker"
+)
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	var r io.Reader = os.Stdin
+	switch len(args) {
+	case 0:
+	case 1:
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	default:
+		return fmt.Errorf("usage: brokertrace [spans.jsonl]")
+	}
+
+	spans, err := broker.ReadSpans(r)
+	if err != nil {
+		return err
+	}
+	return broker.PrintCallTrees(os.Stdout, spans)
+}
@@ -0,0 +1,105 @@
+package broker
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrUnknownReceiver = errors.New("unknown receiver")
+)
+
+type DeadLetterReason uint8
+
+const (
+	DeadLetterUnknownReceiver DeadLetterReason = 1
+	DeadLetterNoSubscriber    DeadLetterReason = 2
+	DeadLetterMemberClosed    DeadLetterReason = 3
+	DeadLetterOverflow        DeadLetterReason = 4
+	DeadLetterRejected        DeadLetterReason = 5
+	DeadLetterDuplicate       DeadLetterReason = 6
+)
+
+type DeadLetter[Command ICommand] struct {
+	Sender   Handle
+	Receiver Handle
+	Cmd      Command
+	Reason   DeadLetterReason
+}
+
+// WithDeadLetters registers a member at sink that publishes every undeliverable message. The
+// dead letter is converted by wrap and sent to all subscribers of the resulting command type.
+func WithDeadLetters[Command ICommand](sink Handle, wrap func(dl DeadLetter[Command]) Command) Option[Command] {
+	return func(c *controller[Command]) {
+		c.deadLetterSink = sink
+		c.wrapDeadLetter = wrap
+	}
+}
+
+func (c *controller[Command]) deadLetter(msg *messageWrapper[Command], reason DeadLetterReason) {
+	// nobody is interested in the member events
+	if c.wrapMemberEvent != nil && msg.sender == c.eventSource {
+		return
+	}
+	if msg.sender != c.deadLetterSink || c.wrapDeadLetter == nil {
+		c.counters.deadLetters.Add(1)
+	}
+	// dead letters that can't be delivered are dropped
+	if c.wrapDeadLetter == nil || msg.sender == c.deadLetterSink {
+		return
+	}
+	c.dispatchMessage(&messageWrapper[Command]{
+		cmd: c.wrapDeadLetter(DeadLetter[Command]{
+			Sender:   msg.sender,
+			Receiver: msg.receiver,
+			Cmd:      msg.cmd,
+			Reason:   reason,
+		}),
+		sender:   c.deadLetterSink,
+		receiver: HandleAny,
+		overflow: OverflowDropOldest,
+	})
+}
+
+func (c *controller[Command]) hasReceiver(receiver Handle) bool {
+	var ok bool
+	c.p.ExecLocked(func() {
+		if receiver.GetSeqID() != HandleAny {
+			ok = c.members[receiver] != nil
+			return
+		}
+		for id := range c.members {
+			if id.GetTypeID() == receiver.GetTypeID() {
+				ok = true
+				return
+			}
+		}
+	})
+	return ok
+}
+
+func getDeadLetterReason(err error) DeadLetterReason {
+	if errors.Is(err, ErrQueueFull) {
+		return DeadLetterOverflow
+	}
+	return DeadLetterMemberClosed
+}
+
+func (r DeadLetterReason) String() string {
+	switch r {
+	case DeadLetterUnknownReceiver:
+		return "unknown receiver"
+	case DeadLetterNoSubscriber:
+		return "no subscriber"
+	case DeadLetterMemberClosed:
+		return "member closed"
+	case DeadLetterOverflow:
+		return "overflow"
+	case DeadLetterRejected:
+		return "rejected"
+	case DeadLetterDuplicate:
+		return "duplicate"
+	default:
+		return fmt.Sprintf("unknown: %v", int(r))
+	}
+}
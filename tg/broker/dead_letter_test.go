@@ -0,0 +1,58 @@
+package broker
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/MrReality255/turbo-go/tg/utils"
+)
+
+const (
+	testTypeDeadLetter = 20
+)
+
+func TestDeadLetters(t *testing.T) {
+	var (
+		sink        = NewHandle(testTypeDeadLetter, 1)
+		deadLetters = make(chan string, 1)
+		b           = New(
+			testDescriptor,
+			time.Second,
+			WithDeadLetters(sink, func(dl DeadLetter[testCmd]) testCmd {
+				return newTestCmd(testTypeDeadLetter, fmt.Sprintf("%v %v: %v", dl.Reason, dl.Receiver, dl.Cmd.Payload))
+			}),
+		)
+	)
+	defer b.Close()
+
+	client := b.AddMember(NewHandle(testTypeClient, 1), nil)
+	b.AddMember(
+		NewHandle(testTypeClient, 2),
+		func(sender Handle, msg testCmd, member IMember[testCmd]) {
+			deadLetters <- fmt.Sprintf("%v %v", sender, msg.Payload)
+		},
+	).Subscribe(testTypeDeadLetter)
+
+	utils.MustSucceed(client.Send(NewHandle(testTypeWorker, 1), newTestCmd(testTypeWorker, "lost")))
+	utils.TestString(
+		t, 1, "unknown receiver",
+		fmt.Sprintf("%v unknown receiver %v: lost", sink, NewHandle(testTypeWorker, 1)),
+		<-deadLetters,
+	)
+
+	utils.MustSucceed(client.Send(HandleAny, newTestCmd(testTypeEvent, "unheard")))
+	utils.TestString(t, 2, "no subscriber", fmt.Sprintf("%v no subscriber 0: unheard", sink), <-deadLetters)
+
+	chDone := make(chan error, 1)
+	go func() {
+		_, err := client.Request(NewHandle(testTypeWorker, 1), newTestCmd(testTypeWorker, "request"))
+		chDone <- err
+	}()
+	select {
+	case err := <-chDone:
+		utils.TestAsString(t, 3, "request", ErrUnknownReceiver.Error(), err)
+	case <-time.After(100 * time.Millisecond):
+		t.Errorf("request to an unknown receiver did not fail immediately")
+	}
+}
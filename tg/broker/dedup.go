@@ -0,0 +1,96 @@
+package broker
+
+import (
+	"sync"
+	"time"
+)
+
+// DedupConfig limits how long and how many message keys are remembered; at least one of
+// Window and Size must be set. Report passes the duplicates to the dead letters with the
+// reason DeadLetterDuplicate instead of dropping them silently.
+type DedupConfig struct {
+	Window time.Duration
+	Size   int
+	Report bool
+}
+
+type deduplicator struct {
+	mx      sync.Mutex
+	config  DedupConfig
+	seen    map[dedupKey]bool
+	entries []dedupEntry
+}
+
+type dedupKey struct {
+	sender   Handle
+	receiver Handle
+	id       Handle
+}
+
+type dedupEntry struct {
+	key dedupKey
+	at  time.Time
+}
+
+// WithDeduplication drops a queued message if a message with the same sender, receiver and ID
+// was dispatched within the window, before it reaches the interceptors and the handlers
+func WithDeduplication[Command ICommand](config DedupConfig) Option[Command] {
+	if config.Window <= 0 && config.Size <= 0 {
+		panic("deduplication requires a window or a size")
+	}
+	return func(c *controller[Command]) {
+		c.dedup = &deduplicator{
+			config: config,
+			seen:   make(map[dedupKey]bool),
+		}
+	}
+}
+
+// isDuplicate reports a duplicate, otherwise the message is remembered
+func (c *controller[Command]) isDuplicate(msg *messageWrapper[Command]) bool {
+	if c.dedup == nil {
+		return false
+	}
+	key := dedupKey{sender: msg.sender, receiver: msg.receiver, id: c.descriptor.GetID(msg.cmd)}
+	if !c.dedup.add(key, c.clock.Now()) {
+		return false
+	}
+
+	c.counters.duplicates.Add(1)
+	if c.dedup.config.Report {
+		c.deadLetter(msg, DeadLetterDuplicate)
+	}
+	return true
+}
+
+// add returns true if the key is already known
+func (d *deduplicator) add(key dedupKey, now time.Time) bool {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	d.expire(now)
+	if d.seen[key] {
+		return true
+	}
+	d.seen[key] = true
+	d.entries = append(d.entries, dedupEntry{key: key, at: now})
+	if d.config.Size > 0 && len(d.entries) > d.config.Size {
+		d.removeFirst()
+	}
+	return false
+}
+
+func (d *deduplicator) expire(now time.Time) {
+	if d.config.Window <= 0 {
+		return
+	}
+	for len(d.entries) > 0 && now.Sub(d.entries[0].at) >= d.config.Window {
+		d.removeFirst()
+	}
+}
+
+func (d *deduplicator) removeFirst() {
+	delete(d.seen, d.entries[0].key)
+	d.entries[0] = dedupEntry{}
+	d.entries = d.entries[1:]
+}
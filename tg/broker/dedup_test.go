@@ -0,0 +1,65 @@
+package broker
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/MrReality255/turbo-go/tg/utils"
+)
+
+func TestDeduplication(t *testing.T) {
+	var (
+		sim      = NewSimulation(1, time.Unix(0, 0))
+		sink     = NewHandle(testTypeDeadLetter, 1)
+		received = utils.NewStringList(16, false)
+		b        = New(
+			testDescriptor,
+			time.Second,
+			WithSimulation[testCmd](sim),
+			WithDeduplication[testCmd](DedupConfig{Window: 10 * time.Second, Size: 2, Report: true}),
+			WithDeadLetters(sink, func(dl DeadLetter[testCmd]) testCmd {
+				return newTestCmd(testTypeDeadLetter, fmt.Sprintf("%v: %v", dl.Reason, dl.Cmd.Payload))
+			}),
+		)
+		workerID = NewHandle(testTypeWorker, 1)
+		client1  = b.AddMember(NewHandle(testTypeClient, 1), nil)
+		client2  = b.AddMember(NewHandle(testTypeClient, 2), nil)
+	)
+	defer b.Close()
+
+	b.AddMember(workerID, func(sender Handle, msg testCmd, member IMember[testCmd]) {
+		received.Add(msg.Payload)
+	})
+	b.AddMember(NewHandle(testTypeClient, 3), func(sender Handle, msg testCmd, member IMember[testCmd]) {
+		received.Add(msg.Payload)
+	}).Subscribe(testTypeDeadLetter)
+
+	send := func(member IMember[testCmd], cmd testCmd, payload string) {
+		cmd.Payload = payload
+		utils.MustSucceed(member.Send(workerID, cmd))
+		sim.Run()
+	}
+	cmdA, cmdB, cmdC := newTestCmd(testTypeWorker, ""), newTestCmd(testTypeWorker, ""), newTestCmd(testTypeWorker, "")
+
+	send(client1, cmdA, "a")
+	send(client1, cmdA, "a again")
+	send(client2, cmdA, "a from client 2")
+	utils.TestAsString(t, 1, "duplicate", "[a duplicate: a again a from client 2]", received.Content())
+
+	sim.Advance(10 * time.Second)
+	send(client1, cmdA, "a expired")
+	send(client1, cmdB, "b")
+	send(client1, cmdC, "c")
+	send(client1, cmdA, "a evicted")
+	send(client1, cmdC, "c again")
+	utils.TestAsString(t, 2, "window", "[a expired b c a evicted duplicate: c again]", received.Content()[3:])
+	utils.TestAsString(t, 3, "stats", "2", b.Stats().Duplicates)
+}
+
+func TestDeduplicationUnbounded(t *testing.T) {
+	defer func() {
+		utils.TestAsString(t, 1, "unbounded", "deduplication requires a window or a size", recover())
+	}()
+	WithDeduplication[testCmd](DedupConfig{Report: true})
+}
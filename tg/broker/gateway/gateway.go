@@ -0,0 +1,282 @@
+// Package gateway exposes a broker to web clients over WebSocket and HTTP
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+	"github.com/kataras/iris/v12"
+
+	"github.com/MrReality255/turbo-go/tg/broker"
+	"github.com/MrReality255/turbo-go/tg/log"
+	"github.com/MrReality255/turbo-go/tg/utils"
+)
+
+var (
+	ErrUnknownOp  = errors.New("unknown operation")
+	ErrNoReceiver = errors.New("request must have a receiver")
+)
+
+const (
+	OpSend        = "send"
+	OpRequest     = "request"
+	OpSubscribe   = "subscribe"
+	OpUnsubscribe = "unsubscribe"
+	OpMessage     = "message"
+	OpResponse    = "response"
+	OpError       = "error"
+	OpAck         = "ack"
+
+	// frameBuffer is the number of frames queued for a client, a client that falls further
+	// behind is disconnected
+	frameBuffer = 64
+	// maxMessageSize limits the size of a WebSocket frame and of an HTTP request body
+	maxMessageSize = 1 << 20
+)
+
+// Frame is a WebSocket message in both directions; Cmd holds the command encoded by the codec
+type Frame struct {
+	Op       string          `json:"op"`
+	ID       string          `json:"id,omitempty"`
+	Sender   broker.Handle   `json:"sender,omitempty"`
+	Receiver broker.Handle   `json:"receiver,omitempty"`
+	Types    []uint32        `json:"types,omitempty"`
+	Topics   []string        `json:"topics,omitempty"`
+	Cmd      json.RawMessage `json:"cmd,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// Gateway adds a broker member of the type ID for every WebSocket connection and HTTP request.
+// The codec must produce JSON.
+type Gateway[Command broker.ICommand] struct {
+	broker   broker.IBroker[Command]
+	typeID   uint32
+	codec    broker.CommandCodec[Command]
+	upgrader websocket.Upgrader
+}
+
+type Option[Command broker.ICommand] func(g *Gateway[Command])
+
+type connection[Command broker.ICommand] struct {
+	gateway *Gateway[Command]
+	conn    *websocket.Conn
+	member  broker.IMember[Command]
+	chWrite chan Frame
+	chDone  chan bool
+}
+
+// New creates a gateway that only accepts WebSocket connections from the same origin
+func New[Command broker.ICommand](
+	b broker.IBroker[Command], typeID uint32, codec broker.CommandCodec[Command], options ...Option[Command],
+) *Gateway[Command] {
+	g := &Gateway[Command]{
+		broker: b,
+		typeID: typeID,
+		codec:  codec,
+	}
+	for _, option := range options {
+		option(g)
+	}
+	return g
+}
+
+// WithCheckOrigin replaces the same origin check of the WebSocket upgrade
+func WithCheckOrigin[Command broker.ICommand](checkOrigin func(r *http.Request) bool) Option[Command] {
+	return func(g *Gateway[Command]) {
+		g.upgrader.CheckOrigin = checkOrigin
+	}
+}
+
+// Register adds the routes: GET /ws, POST /send/{receiver} and POST /request/{receiver}
+func (g *Gateway[Command]) Register(party iris.Party) {
+	party.Get("/ws", g.HandleWebSocket)
+	party.Post("/send/{receiver:uint64}", g.HandleSend)
+	party.Post("/request/{receiver:uint64}", g.HandleRequest)
+}
+
+func (g *Gateway[Command]) HandleWebSocket(ctx iris.Context) {
+	conn, err := g.upgrader.Upgrade(ctx.ResponseWriter(), ctx.Request(), nil)
+	if err != nil {
+		log.Warn("websocket upgrade failed: %v", err)
+		return
+	}
+	conn.SetReadLimit(maxMessageSize)
+
+	c := &connection[Command]{
+		gateway: g,
+		conn:    conn,
+		chWrite: make(chan Frame, frameBuffer),
+		chDone:  make(chan bool),
+	}
+	c.member, err = g.broker.AddNamedMember(g.typeID, g.newName(), c.forward)
+	if err != nil {
+		utils.IgnoreErr(conn.WriteJSON(Frame{Op: OpError, Error: err.Error()}))
+		utils.IgnoreErr(conn.Close())
+		return
+	}
+	go c.writeLoop()
+	c.readLoop()
+}
+
+func (g *Gateway[Command]) HandleSend(ctx iris.Context) {
+	g.handleHTTP(ctx, false, func(member broker.IMember[Command], receiver broker.Handle, cmd Command) (any, error) {
+		return nil, member.Send(receiver, cmd)
+	})
+}
+
+func (g *Gateway[Command]) HandleRequest(ctx iris.Context) {
+	g.handleHTTP(ctx, true, func(member broker.IMember[Command], receiver broker.Handle, cmd Command) (any, error) {
+		resp, err := member.RequestCtx(ctx.Request().Context(), receiver, cmd)
+		if err != nil {
+			return nil, err
+		}
+		data, err := g.codec.Encode(resp)
+		return json.RawMessage(data), err
+	})
+}
+
+// handleHTTP passes the command of the request body to fct; a request needs a receiver
+func (g *Gateway[Command]) handleHTTP(
+	ctx iris.Context,
+	isRequest bool,
+	fct func(member broker.IMember[Command], receiver broker.Handle, cmd Command) (any, error),
+) {
+	wch := utils.NewContextHandler(ctx, func(hint string, err error) {
+		log.Warn("gateway %v: %v", hint, err)
+	})
+	receiver := broker.Handle(ctx.Params().GetUint64Default("receiver", 0))
+	if isRequest && receiver == broker.HandleAny {
+		wch.ClientErr(ctx, ErrNoReceiver)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(ctx.ResponseWriter(), ctx.Request().Body, maxMessageSize))
+	if err != nil {
+		wch.ClientErr(ctx, err)
+		return
+	}
+	cmd, err := g.codec.Decode(body)
+	if err != nil {
+		wch.ClientErr(ctx, err)
+		return
+	}
+
+	member, err := g.broker.AddNamedMember(g.typeID, g.newName(), nil)
+	if err != nil {
+		wch.SrvErr(err)
+		return
+	}
+	defer member.Close()
+
+	result, err := fct(member, receiver, cmd)
+	wch.RespondJSONErr(result, err)
+}
+
+func (g *Gateway[Command]) newName() string {
+	return "gateway/" + utils.GetGUID()
+}
+
+func (c *connection[Command]) readLoop() {
+	defer func() {
+		close(c.chDone)
+		c.member.Close()
+		utils.IgnoreErr(c.conn.Close())
+	}()
+
+	for {
+		var frame Frame
+		if err := c.conn.ReadJSON(&frame); err != nil {
+			return
+		}
+		if err := c.handleFrame(frame); err != nil {
+			c.write(Frame{Op: OpError, ID: frame.ID, Error: err.Error()})
+		}
+	}
+}
+
+func (c *connection[Command]) handleFrame(frame Frame) error {
+	switch frame.Op {
+	case OpSend:
+		cmd, err := c.gateway.codec.Decode(frame.Cmd)
+		if err != nil {
+			return err
+		}
+		return c.member.Send(frame.Receiver, cmd)
+	case OpRequest:
+		if frame.Receiver == broker.HandleAny {
+			return ErrNoReceiver
+		}
+		cmd, err := c.gateway.codec.Decode(frame.Cmd)
+		if err != nil {
+			return err
+		}
+		c.member.RequestMultiple(frame.Receiver, cmd, func(resp Command, err error) bool {
+			c.respond(frame.ID, resp, err)
+			return true
+		})
+		return nil
+	case OpSubscribe:
+		c.member.Subscribe(frame.Types...)
+		if err := c.member.SubscribeTopic(frame.Topics...); err != nil {
+			return err
+		}
+		c.write(Frame{Op: OpAck, ID: frame.ID})
+		return nil
+	case OpUnsubscribe:
+		c.member.Unsubscribe(frame.Types...)
+		c.member.UnsubscribeTopic(frame.Topics...)
+		c.write(Frame{Op: OpAck, ID: frame.ID})
+		return nil
+	default:
+		return ErrUnknownOp
+	}
+}
+
+func (c *connection[Command]) forward(sender broker.Handle, cmd Command, _ broker.IMember[Command]) {
+	data, err := c.gateway.codec.Encode(cmd)
+	if err != nil {
+		log.Warn("unable to encode message: %v", err)
+		return
+	}
+	c.write(Frame{Op: OpMessage, Sender: sender, Cmd: data})
+}
+
+func (c *connection[Command]) respond(id string, resp Command, err error) {
+	frame := Frame{Op: OpResponse, ID: id}
+	if err == nil {
+		frame.Cmd, err = c.gateway.codec.Encode(resp)
+	}
+	if err != nil {
+		frame.Error = err.Error()
+	}
+	c.write(frame)
+}
+
+// write queues a frame for the write loop without blocking the member; a client that doesn't
+// keep up is disconnected, which ends the read loop
+func (c *connection[Command]) write(frame Frame) {
+	select {
+	case <-c.chDone:
+	case c.chWrite <- frame:
+	default:
+		log.Warn("gateway client %v is too slow, disconnecting", c.conn.RemoteAddr())
+		utils.IgnoreErr(c.conn.Close())
+	}
+}
+
+// writeLoop sends the queued frames, a failed write closes the connection
+func (c *connection[Command]) writeLoop() {
+	for {
+		select {
+		case <-c.chDone:
+			return
+		case frame := <-c.chWrite:
+			if err := c.conn.WriteJSON(frame); err != nil {
+				utils.IgnoreErr(c.conn.Close())
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/kataras/iris/v12"
+
+	"github.com/MrReality255/turbo-go/tg/broker"
+	"github.com/MrReality255/turbo-go/tg/utils"
+)
+
+const (
+	testTypeGateway = 1
+	testTypeWorker  = 2
+	testTypeEvent   = 10
+)
+
+type testCmd struct {
+	ID      broker.Handle `json:"id"`
+	Ref     broker.Handle `json:"ref,omitempty"`
+	Payload string        `json:"payload"`
+}
+
+var testDescriptor = broker.CommandDescriptor[testCmd]{
+	GetID: func(cmd testCmd) broker.Handle {
+		return cmd.ID
+	},
+	GetRef: func(cmd testCmd) broker.Handle {
+		return cmd.Ref
+	},
+}
+
+func TestGateway(t *testing.T) {
+	var (
+		b        = broker.New(testDescriptor, time.Second)
+		workerID = broker.NewHandle(testTypeWorker, 1)
+		received = make(chan string, 1)
+		app      = iris.New()
+	)
+	defer b.Close()
+
+	b.AddMember(workerID, func(sender broker.Handle, msg testCmd, member broker.IMember[testCmd]) {
+		if msg.Payload == "event" {
+			received <- msg.Payload
+			return
+		}
+		utils.MustSucceed(member.Send(sender, testCmd{
+			ID:      broker.NewHandle(testTypeWorker, 100),
+			Ref:     msg.ID,
+			Payload: "echo: " + msg.Payload,
+		}))
+	})
+
+	New(b, testTypeGateway, broker.NewJSONCodec[testCmd]()).Register(app.Party("/broker"))
+	utils.MustSucceed(app.Build())
+	srv := httptest.NewServer(app)
+	defer srv.Close()
+
+	// plain HTTP request
+	resp := utils.Must(http.Post(
+		fmt.Sprintf("%v/broker/request/%v", srv.URL, uint64(workerID)),
+		"application/json",
+		strings.NewReader(`{"id": 1, "payload": "http"}`),
+	))
+	body := utils.Must(io.ReadAll(resp.Body))
+	utils.MustSucceed(resp.Body.Close())
+	utils.TestString(t, 1, "http request", fmt.Sprintf(`{"id":%v,"ref":1,"payload":"echo: http"}`, uint64(broker.NewHandle(testTypeWorker, 100))), string(body))
+
+	// websocket
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/broker/ws", nil)
+	utils.MustSucceed(err)
+	defer conn.Close()
+	write := func(frame string) {
+		utils.MustSucceed(conn.WriteMessage(websocket.TextMessage, []byte(frame)))
+	}
+	read := func() Frame {
+		var frame Frame
+		utils.MustSucceed(conn.ReadJSON(&frame))
+		return frame
+	}
+
+	write(fmt.Sprintf(`{"op": "request", "id": "r1", "receiver": %v, "cmd": {"id": 2, "payload": "ws"}}`, uint64(workerID)))
+	frame := read()
+	utils.TestAsString(t, 2, "ws request", fmt.Sprintf(`response r1 {"id":%v,"ref":2,"payload":"echo: ws"}`, uint64(broker.NewHandle(testTypeWorker, 100))), fmt.Sprintf("%v %v %v", frame.Op, frame.ID, compact(frame.Cmd)))
+
+	write(fmt.Sprintf(`{"op": "send", "receiver": %v, "cmd": {"id": 3, "payload": "event"}}`, uint64(workerID)))
+	utils.TestString(t, 3, "ws send", "event", <-received)
+
+	write(fmt.Sprintf(`{"op": "subscribe", "id": "s1", "types": [%v]}`, testTypeEvent))
+	frame = read()
+	utils.TestAsString(t, 4, "ws subscribed", "ack s1", fmt.Sprintf("%v %v", frame.Op, frame.ID))
+	utils.MustSucceed(b.AddMember(broker.NewHandle(testTypeWorker, 2), nil).Send(
+		broker.HandleAny, testCmd{ID: broker.NewHandle(testTypeEvent, 1), Payload: "published"},
+	))
+	frame = read()
+	utils.TestAsString(t, 5, "ws subscribe", fmt.Sprintf(`message {"id":%v,"payload":"published"}`, uint64(broker.NewHandle(testTypeEvent, 1))), fmt.Sprintf("%v %v", frame.Op, compact(frame.Cmd)))
+
+	write(`{"op": "unknown"}`)
+	utils.TestAsString(t, 6, "ws error", ErrUnknownOp.Error(), read().Error)
+	write(`{"op": "request", "id": "r2", "cmd": {"id": 4, "payload": "nobody"}}`)
+	frame = read()
+	utils.TestAsString(t, 7, "ws no receiver", "error r2 "+ErrNoReceiver.Error(), fmt.Sprintf("%v %v %v", frame.Op, frame.ID, frame.Error))
+
+	// a request without a receiver and a frame above the limit are rejected
+	resp = utils.Must(http.Post(srv.URL+"/broker/request/0", "application/json", strings.NewReader(`{"id": 5}`)))
+	utils.MustSucceed(resp.Body.Close())
+	utils.TestAsString(t, 8, "http no receiver", "400", resp.StatusCode)
+	write(fmt.Sprintf(`{"op": "send", "cmd": {"payload": "%v"}}`, strings.Repeat("x", maxMessageSize)))
+	_, _, err = conn.ReadMessage()
+	utils.TestAsString(t, 9, "ws too large", "true", websocket.IsCloseError(err, websocket.CloseMessageTooBig))
+}
+
+func TestGatewayOrigin(t *testing.T) {
+	var (
+		b   = broker.New(testDescriptor, time.Second)
+		app = iris.New()
+	)
+	defer b.Close()
+
+	New(b, testTypeGateway, broker.NewJSONCodec[testCmd]()).Register(app.Party("/same"))
+	New(b, testTypeGateway, broker.NewJSONCodec[testCmd](), WithCheckOrigin[testCmd](func(r *http.Request) bool {
+		return true
+	})).Register(app.Party("/any"))
+	utils.MustSucceed(app.Build())
+	srv := httptest.NewServer(app)
+	defer srv.Close()
+
+	dial := func(path string) string {
+		header := http.Header{"Origin": []string{"https://elsewhere.example"}}
+		conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+path, header)
+		if err != nil {
+			return fmt.Sprint(resp.StatusCode)
+		}
+		utils.IgnoreErr(conn.Close())
+		return "connected"
+	}
+	utils.TestString(t, 1, "foreign origin", "403", dial("/same/ws"))
+	utils.TestString(t, 2, "allowed origin", "connected", dial("/any/ws"))
+}
+
+func compact(data []byte) string {
+	var buf bytes.Buffer
+	utils.MustSucceed(json.Compact(&buf, data))
+	return buf.String()
+}
@@ -0,0 +1,98 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"slices"
+)
+
+var (
+	ErrQuorumNotReached = errors.New("quorum not reached")
+)
+
+// Quorum returns the number of responses needed to complete a gather sent to count members
+type Quorum func(count int) int
+
+type GatherResult[Command ICommand] struct {
+	Responses map[Handle]Command
+	Errors    map[Handle]error
+}
+
+type gatherResponse[Command ICommand] struct {
+	receiver Handle
+	cmd      Command
+	err      error
+}
+
+func QuorumAll(count int) int {
+	return count
+}
+
+func QuorumMajority(count int) int {
+	return count/2 + 1
+}
+
+func QuorumFirst(n int) Quorum {
+	return func(count int) int {
+		return n
+	}
+}
+
+// Gather sends the command as a request to every other member of the type and collects the
+// responses until the quorum is reached. Every request has its own timeout, the requests
+// still running once the result is known are cancelled.
+func (m *memberWrapper[Command]) Gather(
+	ctx context.Context, typeID uint32, cmd Command, quorum Quorum,
+) (GatherResult[Command], error) {
+	var (
+		receivers = m.broker.membersOfType(typeID, m.id)
+		result    = GatherResult[Command]{
+			Responses: make(map[Handle]Command),
+			Errors:    make(map[Handle]error),
+		}
+	)
+	if len(receivers) == 0 {
+		return result, ErrUnknownReceiver
+	}
+	required := min(max(quorum(len(receivers)), 1), len(receivers))
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ch := make(chan gatherResponse[Command], len(receivers))
+	for _, receiver := range receivers {
+		go func() {
+			resp, err := m.RequestCtx(ctx, receiver, cmd)
+			ch <- gatherResponse[Command]{receiver: receiver, cmd: resp, err: err}
+		}()
+	}
+
+	for range receivers {
+		resp := <-ch
+		if resp.err != nil {
+			result.Errors[resp.receiver] = resp.err
+		} else {
+			result.Responses[resp.receiver] = resp.cmd
+		}
+		switch {
+		case len(result.Responses) >= required:
+			return result, nil
+		case len(receivers)-len(result.Errors) < required:
+			return result, ErrQuorumNotReached
+		}
+	}
+	return result, ErrQuorumNotReached
+}
+
+func (c *controller[Command]) membersOfType(typeID uint32, exclude Handle) []Handle {
+	var result []Handle
+	c.p.ExecLocked(func() {
+		for id := range c.members {
+			if (typeID == 0 || id.GetTypeID() == typeID) && id != exclude {
+				result = append(result, id)
+			}
+		}
+	})
+	slices.Sort(result)
+	return result
+}
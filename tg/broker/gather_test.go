@@ -0,0 +1,62 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/MrReality255/turbo-go/tg/utils"
+)
+
+func TestGather(t *testing.T) {
+	var (
+		b      = New(testDescriptor, 50*time.Millisecond)
+		client = b.AddMember(NewHandle(testTypeClient, 1), nil)
+		silent = NewHandle(testTypeWorker, 3)
+		// the second worker answers once the first one was gathered
+		chRelease = make(chan bool)
+	)
+	defer b.Close()
+
+	b.AddMember(NewHandle(testTypeWorker, 1), echoHandler)
+	b.AddMember(NewHandle(testTypeWorker, 2), func(sender Handle, msg testCmd, member IMember[testCmd]) {
+		<-chRelease
+		echoHandler(sender, msg, member)
+	})
+	b.AddMember(silent, nil)
+
+	format := func(r GatherResult[testCmd], err error) string {
+		return fmt.Sprintf("%v %v %v", len(r.Responses), len(r.Errors), err)
+	}
+
+	r, err := client.Gather(context.Background(), testTypeWorker, newTestCmd(testTypeWorker, "first"), QuorumFirst(1))
+	utils.TestAsString(t, 1, "first", "1 0 <nil>", format(r, err))
+	utils.TestAsString(t, 2, "response", "echo: first", r.Responses[NewHandle(testTypeWorker, 1)].Payload)
+	close(chRelease)
+
+	r, err = client.Gather(context.Background(), testTypeWorker, newTestCmd(testTypeWorker, "majority"), QuorumMajority)
+	utils.TestAsString(t, 3, "majority", "2 0 <nil>", format(r, err))
+
+	r, err = client.Gather(context.Background(), testTypeWorker, newTestCmd(testTypeWorker, "all"), QuorumAll)
+	utils.TestAsString(t, 4, "all", "2 1 "+ErrQuorumNotReached.Error(), format(r, err))
+	utils.TestAsString(t, 5, "timeout", ErrRequestTimeout.Error(), r.Errors[silent])
+
+	_, err = client.Gather(context.Background(), testTypeEvent, newTestCmd(testTypeEvent, "none"), QuorumAll)
+	utils.TestAsString(t, 6, "unknown", ErrUnknownReceiver.Error(), err)
+}
+
+// the requests of a gather share the command ID, they are no duplicates of each other
+func TestGatherDeduplication(t *testing.T) {
+	var (
+		b      = New(testDescriptor, time.Second, WithDeduplication[testCmd](DedupConfig{Size: 100}))
+		client = b.AddMember(NewHandle(testTypeClient, 1), nil)
+	)
+	defer b.Close()
+
+	for i := range 2 {
+		b.AddMember(NewHandle(testTypeWorker, uint32(i+1)), echoHandler)
+	}
+	r, err := client.Gather(context.Background(), testTypeWorker, newTestCmd(testTypeWorker, "all"), QuorumAll)
+	utils.TestAsString(t, 1, "all", "2 0 <nil>", fmt.Sprintf("%v %v %v", len(r.Responses), len(r.Errors), err))
+}
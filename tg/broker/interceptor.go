@@ -0,0 +1,102 @@
+package broker
+
+import (
+	"errors"
+
+	"github.com/MrReality255/turbo-go/tg/utils"
+)
+
+var (
+	ErrMessageRejected = errors.New("message rejected")
+)
+
+type Message[Command ICommand] struct {
+	Sender   Handle
+	Receiver Handle
+	Cmd      Command
+}
+
+// Interceptor passes a message on by calling next, possibly with a modified copy. Returning
+// an error without calling next rejects the message, it is reported as a dead letter.
+type Interceptor[Command ICommand] func(msg Message[Command], next func(msg Message[Command]) error) error
+
+// WithDispatchInterceptor adds an interceptor that runs before a queued message is dispatched.
+// It may change the sender, the receiver and the command.
+func WithDispatchInterceptor[Command ICommand](interceptor Interceptor[Command]) Option[Command] {
+	return func(c *controller[Command]) {
+		c.dispatchInterceptors = append(c.dispatchInterceptors, interceptor)
+	}
+}
+
+// WithHandleInterceptor adds an interceptor that runs around every member handler call. The
+// receiver is the handling member, changing it has no effect.
+func WithHandleInterceptor[Command ICommand](interceptor Interceptor[Command]) Option[Command] {
+	return func(c *controller[Command]) {
+		c.handleInterceptors = append(c.handleInterceptors, interceptor)
+	}
+}
+
+func runInterceptors[Command ICommand](
+	chain []Interceptor[Command], msg Message[Command], final func(msg Message[Command]) error,
+) error {
+	if len(chain) == 0 {
+		return final(msg)
+	}
+	return chain[0](msg, func(msg Message[Command]) error {
+		return runInterceptors(chain[1:], msg, final)
+	})
+}
+
+func (c *controller[Command]) processMessage(msg *messageWrapper[Command]) bool {
+	if c.isDuplicate(msg) {
+		return true
+	}
+
+	var isDispatched bool
+	err := runInterceptors(c.dispatchInterceptors, msg.message(), func(m Message[Command]) error {
+		isDispatched = c.dispatchMessage(msg.with(m))
+		return nil
+	})
+	if err != nil {
+		c.deadLetter(msg, DeadLetterRejected)
+		return true
+	}
+	return isDispatched
+}
+
+func (m *memberWrapper[Command]) callHandler(msg *messageWrapper[Command], w *worker[Command]) {
+	defer m.recoverHandler(msg)
+
+	// the span of a request is recorded by the requesting member
+	outcome := OutcomeFailed
+	if msg.span != nil && !msg.span.isRequest {
+		defer func() {
+			m.broker.exportSpan(msg.span, msg.sender, m.id, msg.cmd, outcome)
+		}()
+	}
+
+	err := runInterceptors(
+		m.broker.handleInterceptors,
+		Message[Command]{Sender: msg.sender, Receiver: m.id, Cmd: msg.cmd},
+		func(im Message[Command]) error {
+			if handler := m.handler(); handler != nil {
+				handler(im.Sender, im.Cmd, m.forHandler(msg.span, w))
+			}
+			return nil
+		},
+	)
+	outcome = utils.IfThen(err == nil, OutcomeOK, OutcomeRejected)
+	if err != nil {
+		m.broker.deadLetter(msg, DeadLetterRejected)
+	}
+}
+
+func (msg *messageWrapper[Command]) message() Message[Command] {
+	return Message[Command]{Sender: msg.sender, Receiver: msg.receiver, Cmd: msg.cmd}
+}
+
+func (msg *messageWrapper[Command]) with(m Message[Command]) *messageWrapper[Command] {
+	result := *msg
+	result.sender, result.receiver, result.cmd = m.Sender, m.Receiver, m.Cmd
+	return &result
+}
@@ -0,0 +1,59 @@
+package broker
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/MrReality255/turbo-go/tg/utils"
+)
+
+func TestInterceptors(t *testing.T) {
+	var (
+		sink     = NewHandle(testTypeDeadLetter, 1)
+		received = make(chan string, 2)
+		handled  = utils.NewStringList(16, false)
+		b        = New(
+			testDescriptor,
+			time.Second,
+			WithDeadLetters(sink, func(dl DeadLetter[testCmd]) testCmd {
+				return newTestCmd(testTypeDeadLetter, fmt.Sprintf("%v: %v", dl.Reason, dl.Cmd.Payload))
+			}),
+			WithDispatchInterceptor(func(msg Message[testCmd], next func(msg Message[testCmd]) error) error {
+				if msg.Cmd.Payload == "blocked" {
+					return ErrMessageRejected
+				}
+				return next(msg)
+			}),
+			WithDispatchInterceptor(func(msg Message[testCmd], next func(msg Message[testCmd]) error) error {
+				if msg.Sender != sink {
+					msg.Cmd.Payload = "enriched " + msg.Cmd.Payload
+				}
+				return next(msg)
+			}),
+			WithHandleInterceptor(func(msg Message[testCmd], next func(msg Message[testCmd]) error) error {
+				handled.Addf("%v -> %v", msg.Sender, msg.Receiver)
+				return next(msg)
+			}),
+		)
+	)
+	defer b.Close()
+
+	client := b.AddMember(NewHandle(testTypeClient, 1), nil)
+	worker := b.AddMember(NewHandle(testTypeWorker, 1), func(sender Handle, msg testCmd, member IMember[testCmd]) {
+		received <- msg.Payload
+	})
+	worker.Subscribe(testTypeDeadLetter)
+
+	utils.MustSucceed(client.Send(NewHandle(testTypeWorker, 1), newTestCmd(testTypeWorker, "blocked")))
+	utils.TestString(t, 1, "rejected", "rejected: blocked", <-received)
+
+	utils.MustSucceed(client.Send(NewHandle(testTypeWorker, 1), newTestCmd(testTypeWorker, "passed")))
+	utils.TestString(t, 2, "modified", "enriched passed", <-received)
+
+	utils.TestAsString(
+		t, 3, "handled",
+		fmt.Sprintf("[%v -> %v %v -> %v]", sink, NewHandle(testTypeWorker, 1), NewHandle(testTypeClient, 1), NewHandle(testTypeWorker, 1)),
+		handled.Content(),
+	)
+}
@@ -0,0 +1,287 @@
+package broker
+
+import (
+	"errors"
+	"sync"
+)
+
+const (
+	mailboxSize    = 64
+	mailboxWorkers = 1
+	// a lower lane is served at least once per starvationLimit items of the higher lanes
+	starvationLimit = 16
+)
+
+var (
+	ErrBrokerClosed = errors.New("broker closed")
+	ErrMemberClosed = errors.New("member closed")
+	ErrQueueFull    = errors.New("queue is full")
+)
+
+type OverflowPolicy uint8
+
+const (
+	// OverflowBlock waits until there is room in the queue
+	OverflowBlock OverflowPolicy = 0
+	// OverflowDropOldest removes the oldest queued message to make room
+	OverflowDropOldest OverflowPolicy = 1
+	// OverflowError rejects the message with ErrQueueFull
+	OverflowError OverflowPolicy = 2
+)
+
+type SendOption func(o *sendOptions)
+
+type sendOptions struct {
+	overflow OverflowPolicy
+	priority *Priority
+	parent   *traceSpan
+	span     *traceSpan
+}
+
+// mailbox is a bounded FIFO queue, used for the broker queue and for the member mailboxes.
+// It may have several lanes sharing the capacity, higher lanes are drained first.
+type mailbox[T any] struct {
+	mx        sync.Mutex
+	notEmpty  *sync.Cond
+	notFull   *sync.Cond
+	errClosed error
+
+	lanes    []ring[T]
+	skipped  []int
+	backlog  []T
+	size     int
+	count    int
+	isClosed bool
+}
+
+type ring[T any] struct {
+	items []T
+	head  int
+	count int
+}
+
+func WithOverflow(policy OverflowPolicy) SendOption {
+	return func(o *sendOptions) {
+		o.overflow = policy
+	}
+}
+
+func newSendOptions(options []SendOption) sendOptions {
+	var o sendOptions
+	for _, option := range options {
+		option(&o)
+	}
+	return o
+}
+
+func newMailbox[T any](size int, errClosed error) *mailbox[T] {
+	return newLaneMailbox[T](size, 1, errClosed)
+}
+
+func newLaneMailbox[T any](size int, lanes int, errClosed error) *mailbox[T] {
+	b := &mailbox[T]{
+		lanes:     make([]ring[T], max(lanes, 1)),
+		skipped:   make([]int, max(lanes, 1)),
+		size:      max(size, 1),
+		errClosed: errClosed,
+	}
+	for i := range b.lanes {
+		b.lanes[i].items = make([]T, b.size)
+	}
+	b.notEmpty = sync.NewCond(&b.mx)
+	b.notFull = sync.NewCond(&b.mx)
+	return b
+}
+
+// push adds an item to the queue and returns the items that were dropped to make room for it
+func (b *mailbox[T]) push(item T, policy OverflowPolicy) ([]T, error) {
+	return b.pushLane(item, 0, policy)
+}
+
+// pushLane adds an item to the given lane; when the mailbox is full, OverflowDropOldest drops
+// the oldest item of the lowest lane
+func (b *mailbox[T]) pushLane(item T, lane int, policy OverflowPolicy) ([]T, error) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	var dropped []T
+	for !b.isClosed && b.count == b.size {
+		switch policy {
+		case OverflowDropOldest:
+			dropped = append(dropped, b.popLaneLocked(b.lowestLaneLocked()))
+		case OverflowError:
+			return nil, ErrQueueFull
+		default:
+			b.notFull.Wait()
+		}
+	}
+	if b.isClosed {
+		return dropped, b.errClosed
+	}
+	b.addLocked(item, lane)
+	return dropped, nil
+}
+
+// offer adds an item to the first lane without waiting. When the mailbox is full, OverflowBlock
+// parks the item in the backlog, which refills the mailbox in order as items are popped. A
+// bounded item is rejected with ErrQueueFull once the backlog holds size items, the others rely
+// on the backpressure of waitRoom.
+func (b *mailbox[T]) offer(item T, policy OverflowPolicy, isBounded bool) ([]T, error) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	if b.isClosed {
+		return nil, b.errClosed
+	}
+
+	var dropped []T
+	if b.count == b.size {
+		switch policy {
+		case OverflowDropOldest:
+			dropped = append(dropped, b.popLaneLocked(b.lowestLaneLocked()))
+			b.refillLocked()
+		case OverflowError:
+			return nil, ErrQueueFull
+		}
+	}
+	if b.count == b.size || len(b.backlog) > 0 {
+		if isBounded && len(b.backlog) >= b.size {
+			return dropped, ErrQueueFull
+		}
+		b.backlog = append(b.backlog, item)
+		return dropped, nil
+	}
+	b.addLocked(item, 0)
+	return dropped, nil
+}
+
+// waitRoom waits until the mailbox and its backlog have room for another item, or until the
+// mailbox is closed
+func (b *mailbox[T]) waitRoom() {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	for !b.isClosed && b.count+len(b.backlog) >= b.size {
+		b.notFull.Wait()
+	}
+}
+
+// pop waits for the next item; it fails once the mailbox is closed
+func (b *mailbox[T]) pop() (T, bool) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	for !b.isClosed && b.count == 0 {
+		b.notEmpty.Wait()
+	}
+	if b.isClosed {
+		var dummy T
+		return dummy, false
+	}
+	item := b.popLaneLocked(b.nextLaneLocked())
+	b.refillLocked()
+	b.notFull.Signal()
+	return item, true
+}
+
+// tryPop returns the next item without waiting
+func (b *mailbox[T]) tryPop() (T, bool) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	if b.isClosed || b.count == 0 {
+		var dummy T
+		return dummy, false
+	}
+	item := b.popLaneLocked(b.nextLaneLocked())
+	b.refillLocked()
+	b.notFull.Signal()
+	return item, true
+}
+
+func (b *mailbox[T]) len() int {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	return b.count + len(b.backlog)
+}
+
+// close stops the mailbox and returns the items that were not processed
+func (b *mailbox[T]) close() []T {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	b.isClosed = true
+	result := make([]T, 0, b.count+len(b.backlog))
+	for lane := len(b.lanes) - 1; lane >= 0; lane-- {
+		for b.lanes[lane].count > 0 {
+			result = append(result, b.popLaneLocked(lane))
+		}
+	}
+	result = append(result, b.backlog...)
+	b.backlog = nil
+	b.notEmpty.Broadcast()
+	b.notFull.Broadcast()
+	return result
+}
+
+// nextLaneLocked returns the highest lane with items, unless a lower lane was skipped too often
+func (b *mailbox[T]) nextLaneLocked() int {
+	result := -1
+	for lane := len(b.lanes) - 1; lane >= 0; lane-- {
+		switch {
+		case b.lanes[lane].count == 0:
+			continue
+		case result < 0:
+			result = lane
+		case b.skipped[lane] >= starvationLimit:
+			result = lane
+		}
+	}
+	for lane := range b.lanes {
+		switch {
+		case lane == result:
+			b.skipped[lane] = 0
+		case lane < result && b.lanes[lane].count > 0:
+			b.skipped[lane]++
+		}
+	}
+	return result
+}
+
+func (b *mailbox[T]) lowestLaneLocked() int {
+	for lane := range b.lanes {
+		if b.lanes[lane].count > 0 {
+			return lane
+		}
+	}
+	return 0
+}
+
+func (b *mailbox[T]) addLocked(item T, lane int) {
+	r := &b.lanes[min(max(lane, 0), len(b.lanes)-1)]
+	r.items[(r.head+r.count)%len(r.items)] = item
+	r.count++
+	b.count++
+	b.notEmpty.Signal()
+}
+
+// refillLocked moves the parked items into the free room of the first lane
+func (b *mailbox[T]) refillLocked() {
+	for len(b.backlog) > 0 && b.count < b.size {
+		var dummy T
+		b.addLocked(b.backlog[0], 0)
+		b.backlog[0] = dummy
+		b.backlog = b.backlog[1:]
+	}
+}
+
+func (b *mailbox[T]) popLaneLocked(lane int) T {
+	var (
+		dummy T
+		r     = &b.lanes[lane]
+	)
+	item := r.items[r.head]
+	r.items[r.head] = dummy
+	r.head = (r.head + 1) % len(r.items)
+	r.count--
+	b.count--
+	return item
+}
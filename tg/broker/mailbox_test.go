@@ -0,0 +1,176 @@
+package broker
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MrReality255/turbo-go/tg/utils"
+)
+
+func TestMailboxOverflow(t *testing.T) {
+	b := newMailbox[int](2, ErrMemberClosed)
+	for i := 1; i <= 2; i++ {
+		_, err := b.push(i, OverflowError)
+		utils.TestAsString(t, i, "push", "<nil>", err)
+	}
+
+	_, err := b.push(3, OverflowError)
+	utils.TestAsString(t, 3, "error", ErrQueueFull.Error(), err)
+
+	dropped, err := b.push(3, OverflowDropOldest)
+	utils.TestAsString(t, 4, "drop oldest", "[1] <nil>", fmt.Sprintf("%v %v", dropped, err))
+
+	// a blocked push continues once there is room
+	chPushed := make(chan error, 1)
+	go func() {
+		_, err := b.push(4, OverflowBlock)
+		chPushed <- err
+	}()
+	item, _ := b.pop()
+	utils.TestAsString(t, 5, "pop", "2", item)
+	utils.TestAsString(t, 6, "blocked push", "<nil>", <-chPushed)
+	utils.TestAsString(t, 7, "close", "[3 4]", b.close())
+
+	_, err = b.push(5, OverflowBlock)
+	utils.TestAsString(t, 8, "closed", ErrMemberClosed.Error(), err)
+}
+
+func TestMailboxBacklog(t *testing.T) {
+	b := newMailbox[int](2, ErrMemberClosed)
+	for i := 1; i <= 4; i++ {
+		_, err := b.offer(i, OverflowBlock, true)
+		utils.TestAsString(t, i, "offer", "<nil>", err)
+	}
+	utils.TestAsString(t, 5, "parked", "4", b.len())
+
+	_, err := b.offer(5, OverflowError, true)
+	utils.TestAsString(t, 6, "error", ErrQueueFull.Error(), err)
+	_, err = b.offer(5, OverflowBlock, true)
+	utils.TestAsString(t, 6, "backlog full", ErrQueueFull.Error(), err)
+	dropped, _ := b.offer(5, OverflowDropOldest, true)
+	utils.TestAsString(t, 7, "drop oldest", "[1]", dropped)
+
+	// the room is only free once the backlog is gone
+	chRoom := make(chan bool)
+	go func() {
+		b.waitRoom()
+		close(chRoom)
+	}()
+	var popped []int
+	for range 3 {
+		item, _ := b.pop()
+		popped = append(popped, item)
+	}
+	<-chRoom
+	utils.TestAsString(t, 8, "order", "[2 3 4] [5]", fmt.Sprintf("%v %v", popped, b.close()))
+}
+
+// a member waiting for a response must not stall the delivery to the other members
+func TestMailboxFull(t *testing.T) {
+	var (
+		b        = New(testDescriptor, 500*time.Millisecond)
+		workerID = NewHandle(testTypeWorker, 1)
+		nestedID = NewHandle(testTypeWorker, 2)
+		results  = make(chan string, 4)
+	)
+	defer b.Close()
+
+	b.AddMember(workerID, echoHandler)
+	b.AddMember(nestedID, func(sender Handle, msg testCmd, member IMember[testCmd]) {
+		resp, err := member.Request(workerID, newTestCmd(testTypeWorker, msg.Payload))
+		results <- fmt.Sprintf("%v %v", resp.Payload, err)
+	}, WithMemberMailbox[testCmd](1, 1))
+
+	client := b.AddMember(NewHandle(testTypeClient, 1), nil)
+	for i := range 4 {
+		utils.MustSucceed(client.Send(nestedID, newTestCmd(testTypeWorker, fmt.Sprint(i))))
+	}
+	for i := range 4 {
+		utils.TestAsString(t, i+1, "nested request", fmt.Sprintf("echo: %v <nil>", i), <-results)
+	}
+}
+
+// a slow subscriber keeps at most a mailbox and a backlog of broadcasts, the rest is dead-lettered
+func TestMailboxBroadcastOverflow(t *testing.T) {
+	const sent = 100
+
+	var (
+		b         = New(testDescriptor, time.Second)
+		chRelease = make(chan bool)
+		client    = b.AddMember(NewHandle(testTypeClient, 1), nil)
+	)
+	defer b.Close()
+	defer close(chRelease)
+
+	b.AddMember(NewHandle(testTypeWorker, 1), func(sender Handle, msg testCmd, member IMember[testCmd]) {
+		<-chRelease
+	}, WithMemberMailbox[testCmd](4, 1)).Subscribe(testTypeEvent)
+	for i := range sent {
+		utils.MustSucceed(client.Send(HandleAny, newTestCmd(testTypeEvent, fmt.Sprint(i))))
+	}
+
+	waitUntil(t, "all broadcasts handled", func() bool {
+		stats := b.Stats()
+		return stats.Delivered+stats.DeadLetters == sent
+	})
+	stats := b.Stats()
+	utils.TestAsString(
+		t, 1, "bounded", "true true",
+		fmt.Sprintf("%v %v", stats.Members[testTypeWorker][0].Mailbox <= 8, stats.DeadLetters >= sent-9),
+	)
+}
+
+func TestMailboxOrder(t *testing.T) {
+	var (
+		b        = New(testDescriptor, time.Second)
+		received = make(chan string, 100)
+		expected = make([]string, 0, 100)
+	)
+	defer b.Close()
+
+	b.AddMember(
+		NewHandle(testTypeWorker, 1),
+		func(sender Handle, msg testCmd, member IMember[testCmd]) {
+			received <- msg.Payload
+		},
+		WithMemberMailbox[testCmd](8, 1),
+	)
+	client := b.AddMember(NewHandle(testTypeClient, 1), nil)
+	for i := 0; i < 100; i++ {
+		expected = append(expected, fmt.Sprint(i))
+		utils.MustSucceed(client.Send(NewHandle(testTypeWorker, 1), newTestCmd(testTypeWorker, fmt.Sprint(i))))
+	}
+
+	result := make([]string, 0, 100)
+	for range expected {
+		result = append(result, <-received)
+	}
+	utils.TestString(t, 1, "order", strings.Join(expected, ","), strings.Join(result, ","))
+}
+
+func TestMailboxLanes(t *testing.T) {
+	b := newLaneMailbox[string](64, priorityLanes, ErrBrokerClosed)
+	push := func(priority Priority, items ...string) {
+		for _, item := range items {
+			utils.Must(b.pushLane(item, priority.lane(), OverflowError))
+		}
+	}
+	push(PriorityLow, "l0", "l1")
+	push(PriorityNormal, "n0")
+	for i := range starvationLimit + 2 {
+		push(PriorityHigh, fmt.Sprintf("h%v", i))
+	}
+
+	var result []string
+	for b.len() > 0 {
+		item, _ := b.pop()
+		result = append(result, item)
+	}
+	utils.TestString(
+		t, 1, "order",
+		"h0,h1,h2,h3,h4,h5,h6,h7,h8,h9,h10,h11,h12,h13,h14,h15,l0,n0,h16,h17,l1",
+		strings.Join(result, ","),
+	)
+}
@@ -1,28 +1,55 @@
 package broker
 
 import (
+	"context"
+	"iter"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/MrReality255/turbo-go/tg/utils"
 )
 
 type IMember[Command ICommand] interface {
-	Request(receiver Handle, cmd Command) (Command, error)
+	Request(receiver Handle, cmd Command, options ...RequestOption) (Command, error)
+	RequestCtx(ctx context.Context, receiver Handle, cmd Command, options ...RequestOption) (Command, error)
 	RequestMultiple(receiver Handle, cmd Command, handler RequestHandler[Command])
-	Send(receiver Handle, cmd Command)
+	RequestMultipleCtx(ctx context.Context, receiver Handle, cmd Command, handler RequestHandler[Command])
+	RequestStream(receiver Handle, cmd Command) iter.Seq2[Command, error]
+	Gather(ctx context.Context, typeID uint32, cmd Command, quorum Quorum) (GatherResult[Command], error)
+	Send(receiver Handle, cmd Command, options ...SendOption) error
+	SendAfter(receiver Handle, cmd Command, delay time.Duration, options ...SendOption) (IScheduled, error)
+	SendAt(receiver Handle, cmd Command, at time.Time, options ...SendOption) (IScheduled, error)
 	Subscribe(cmdType ...uint32)
+	SubscribeFunc(filter SubscriptionFilter[Command]) (cancel func())
+	Unsubscribe(cmdType ...uint32)
+	SubscribeTopic(patterns ...string) error
+	UnsubscribeTopic(patterns ...string)
+	ListMembers(typeID uint32) []Handle
+	LookupName(name string) (Handle, bool)
+	CircuitState(receiver Handle) CircuitState
+	ShutdownBroker(ctx context.Context) error
 	Close()
 }
 
 type memberWrapper[Command ICommand] struct {
 	id             Handle
+	name           string
 	descriptor     CommandDescriptor[Command]
 	messageHandler MemberMessageHandler[Command]
 	broker         *controller[Command]
+	link           *bridgeLink[Command]
 	requestTimeout time.Duration
+	mailboxSize    int
+	mailboxWorkers int
+	mailbox        *mailbox[*messageWrapper[Command]]
+	inFlight       atomic.Int64
+	policy         SupervisionPolicy
+	restartFct     func() MemberMessageHandler[Command]
+	breakerConfig  *CircuitBreakerConfig
 
-	reqManager map[Handle]IRequestManager[Command]
+	reqManager map[Handle]*requestManager[Command]
+	breakers   map[Handle]*circuitBreaker
 	mx         sync.Mutex
 }
 
@@ -30,9 +57,22 @@ func (m *memberWrapper[Command]) Close() {
 	m.broker.removeMember(m.id)
 }
 
-func (m *memberWrapper[Command]) Request(receiver Handle, cmd Command) (Command, error) {
+func (m *memberWrapper[Command]) Request(receiver Handle, cmd Command, options ...RequestOption) (Command, error) {
+	return m.RequestCtx(context.Background(), receiver, cmd, options...)
+}
+
+func (m *memberWrapper[Command]) RequestCtx(
+	ctx context.Context, receiver Handle, cmd Command, options ...RequestOption,
+) (Command, error) {
+	if o := newRequestOptions(options); o.retry != nil {
+		return m.requestWithRetry(ctx, receiver, cmd, *o.retry)
+	}
+	return m.requestOnce(ctx, receiver, cmd)
+}
+
+func (m *memberWrapper[Command]) requestOnce(ctx context.Context, receiver Handle, cmd Command) (Command, error) {
 	chResponse := make(chan *utils.ItemWithErr[Command], 1)
-	m.RequestMultiple(receiver, cmd, func(cmd Command, err error) bool {
+	m.RequestMultipleCtx(ctx, receiver, cmd, func(cmd Command, err error) bool {
 		chResponse <- &utils.ItemWithErr[Command]{
 			Data: cmd,
 			Err:  err,
@@ -45,40 +85,166 @@ func (m *memberWrapper[Command]) Request(receiver Handle, cmd Command) (Command,
 
 func (m *memberWrapper[Command]) RequestMultiple(
 	receiver Handle, cmd Command, handler RequestHandler[Command],
+) {
+	m.RequestMultipleCtx(context.Background(), receiver, cmd, handler)
+}
+
+func (m *memberWrapper[Command]) RequestMultipleCtx(
+	ctx context.Context, receiver Handle, cmd Command, handler RequestHandler[Command],
 ) {
 	if receiver == HandleAny {
 		panic("request must have a receiver")
 	}
-	m.getReqManager(receiver).RequestMultiple(cmd, handler)
+	ctx, handler = m.traceRequest(ctx, receiver, cmd, handler)
+	handler, isAllowed := m.guardRequest(receiver, handler)
+	if !isAllowed {
+		return
+	}
+	if !m.broker.hasReceiver(receiver) {
+		m.broker.spawn(func() {
+			var dummy Command
+			_ = handler(dummy, ErrUnknownReceiver)
+		})
+		return
+	}
+	m.getReqManager(receiver).RequestMultipleCtx(ctx, cmd, handler)
+}
+
+func (m *memberWrapper[Command]) RequestStream(receiver Handle, cmd Command) iter.Seq2[Command, error] {
+	return streamRequest(m.descriptor.IsLast, func(ctx context.Context, handler RequestHandler[Command]) {
+		m.RequestMultipleCtx(ctx, receiver, cmd, handler)
+	})
 }
 
-func (m *memberWrapper[Command]) Send(receiver Handle, cmd Command) {
-	go m.broker.send(m.id, receiver, cmd)
+func (m *memberWrapper[Command]) Send(receiver Handle, cmd Command, options ...SendOption) error {
+	return m.broker.send(m.id, receiver, cmd, newSendOptions(options))
 }
 
 func (m *memberWrapper[Command]) Subscribe(cmdType ...uint32) {
 	m.broker.subscribe(m.id, cmdType...)
 }
 
-func (m *memberWrapper[Command]) getReqManager(receiver Handle) IRequestManager[Command] {
+func (m *memberWrapper[Command]) SubscribeFunc(filter SubscriptionFilter[Command]) func() {
+	return m.broker.subscribeFunc(m.id, filter)
+}
+
+func (m *memberWrapper[Command]) Unsubscribe(cmdType ...uint32) {
+	m.broker.unsubscribe(m.id, cmdType...)
+}
+
+// accepts reports whether the message may be delivered to the member: proxies of a bridge
+// never receive messages that arrived over the same bridge
+func (m *memberWrapper[Command]) accepts(msg *messageWrapper[Command]) bool {
+	return m != nil && (msg.via == nil || m.link != msg.via)
+}
+
+func (m *memberWrapper[Command]) getReqManager(receiver Handle) *requestManager[Command] {
 	m.mx.Lock()
 	defer m.mx.Unlock()
 	if m.reqManager[receiver] == nil {
-		m.reqManager[receiver] = NewRequestManager[Command](
+		m.reqManager[receiver] = newRequestManager[Command](
 			m.descriptor,
 			func(cmd Command) error {
-				m.Send(receiver, cmd)
-				return nil
+				return m.Send(receiver, cmd)
 			},
 			func(cmd Command) {
-				m.messageHandler(receiver, cmd, m)
+				if handler := m.handler(); handler != nil {
+					handler(receiver, cmd, m)
+				}
 			},
 			m.requestTimeout,
 		)
+		m.reqManager[receiver].counters = &m.broker.counters
+		m.reqManager[receiver].clock = m.broker.clock
+		m.reqManager[receiver].spawn = m.broker.spawn
+		m.reqManager[receiver].sendCtx = func(ctx context.Context, cmd Command) error {
+			return m.broker.send(m.id, receiver, cmd, sendOptions{span: spanFromContext(ctx)})
+		}
+	}
+	return m.reqManager[receiver]
+}
+
+func (m *memberWrapper[Command]) handleMessage(msg *messageWrapper[Command]) error {
+	// responses bypass the mailbox, a handler waiting for a response must not block its delivery
+	if m.acceptResponse(msg) {
+		m.broker.counters.delivered.Add(1)
+		return nil
 	}
+
+	// the dispatcher never waits for a full mailbox, the senders of messages with an exact
+	// receiver wait in Send instead; the other messages have a bounded backlog
+	m.inFlight.Add(1)
+	dropped, err := m.mailbox.offer(msg, msg.overflow, msg.receiver.GetSeqID() == HandleAny)
+	for _, d := range dropped {
+		m.inFlight.Add(-1)
+		m.broker.deadLetter(d, DeadLetterOverflow)
+	}
+	if err != nil {
+		m.inFlight.Add(-1)
+		m.broker.deadLetter(msg, getDeadLetterReason(err))
+		return err
+	}
+	m.broker.counters.delivered.Add(1)
+	if m.broker.sim != nil {
+		m.broker.sim.post(func() {
+			if msg, ok := m.mailbox.tryPop(); ok {
+				m.handleQueued(msg, nil)
+			}
+		})
+	}
+	return nil
+}
+
+// acceptResponse matches the message with the requests sent to the sender, or to its type
+func (m *memberWrapper[Command]) acceptResponse(msg *messageWrapper[Command]) bool {
+	for _, receiver := range []Handle{msg.sender, NewHandleType(msg.sender.GetTypeID())} {
+		if rm := m.findReqManager(receiver); rm != nil && rm.acceptResponse(msg.cmd) {
+			return true
+		}
+	}
+	return false
+}
+
+// expectsResponse reports whether the command answers an active request of the member
+func (m *memberWrapper[Command]) expectsResponse(sender Handle, cmd Command) bool {
+	refID := m.descriptor.GetRef(cmd)
+	for _, receiver := range []Handle{sender, NewHandleType(sender.GetTypeID())} {
+		if rm := m.findReqManager(receiver); rm != nil && rm.isActive(refID) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *memberWrapper[Command]) findReqManager(receiver Handle) *requestManager[Command] {
+	m.mx.Lock()
+	defer m.mx.Unlock()
 	return m.reqManager[receiver]
 }
 
-func (m *memberWrapper[Command]) handleMessage(sender Handle, cmd Command) {
-	m.getReqManager(sender).Accept(cmd)
+func (m *memberWrapper[Command]) start() {
+	m.mailbox = newMailbox[*messageWrapper[Command]](m.mailboxSize, ErrMemberClosed)
+	if m.broker.sim != nil {
+		return
+	}
+	for i := 0; i < max(m.mailboxWorkers, 1); i++ {
+		m.broker.tasks.add()
+		go m.work(&worker[Command]{member: m})
+	}
+}
+
+func (m *memberWrapper[Command]) work(w *worker[Command]) {
+	defer m.broker.releaseWorker(w)
+	for {
+		msg, ok := m.mailbox.pop()
+		if !ok {
+			return
+		}
+		m.handleQueued(msg, w)
+	}
+}
+
+func (m *memberWrapper[Command]) handleQueued(msg *messageWrapper[Command], w *worker[Command]) {
+	m.callHandler(msg, w)
+	m.inFlight.Add(-1)
 }
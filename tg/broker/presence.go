@@ -0,0 +1,58 @@
+package broker
+
+import "fmt"
+
+type MemberEventKind uint8
+
+const (
+	MemberJoined MemberEventKind = 1
+	MemberLeft   MemberEventKind = 2
+)
+
+type MemberEvent struct {
+	Kind   MemberEventKind
+	Handle Handle
+	TypeID uint32
+}
+
+// WithMemberEvents registers a member at source that publishes an event whenever a local member
+// is added or closed. The event is converted by wrap and sent to all subscribers of the
+// resulting command type.
+func WithMemberEvents[Command ICommand](source Handle, wrap func(ev MemberEvent) Command) Option[Command] {
+	return func(c *controller[Command]) {
+		c.eventSource = source
+		c.wrapMemberEvent = wrap
+	}
+}
+
+// ListMembers returns the members with the type ID, or all members if typeID is 0
+func (c *controller[Command]) ListMembers(typeID uint32) []Handle {
+	return c.membersOfType(typeID, HandleAny)
+}
+
+func (m *memberWrapper[Command]) ListMembers(typeID uint32) []Handle {
+	return m.broker.ListMembers(typeID)
+}
+
+func (c *controller[Command]) memberEvent(kind MemberEventKind, id Handle) {
+	if c.wrapMemberEvent == nil || id == c.eventSource {
+		return
+	}
+	c.dispatchMessage(&messageWrapper[Command]{
+		cmd:      c.wrapMemberEvent(MemberEvent{Kind: kind, Handle: id, TypeID: id.GetTypeID()}),
+		sender:   c.eventSource,
+		receiver: HandleAny,
+		overflow: OverflowDropOldest,
+	})
+}
+
+func (k MemberEventKind) String() string {
+	switch k {
+	case MemberJoined:
+		return "joined"
+	case MemberLeft:
+		return "left"
+	default:
+		return fmt.Sprintf("unknown: %v", int(k))
+	}
+}
@@ -0,0 +1,47 @@
+package broker
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/MrReality255/turbo-go/tg/utils"
+)
+
+const (
+	testTypeMemberEvent = 21
+)
+
+func TestMemberEvents(t *testing.T) {
+	var (
+		source = NewHandle(testTypeMemberEvent, 1)
+		events = make(chan string, 4)
+		b      = New(
+			testDescriptor,
+			time.Second,
+			WithMemberEvents(source, func(ev MemberEvent) testCmd {
+				return newTestCmd(testTypeMemberEvent, fmt.Sprintf("%v %v %v", ev.Kind, ev.Handle, ev.TypeID))
+			}),
+		)
+	)
+	defer b.Close()
+
+	b.AddMember(NewHandle(testTypeClient, 1), func(sender Handle, msg testCmd, member IMember[testCmd]) {
+		events <- msg.Payload
+	}).Subscribe(testTypeMemberEvent)
+
+	worker := b.AddMember(NewHandle(testTypeWorker, 1), nil)
+	utils.TestString(t, 1, "joined", fmt.Sprintf("joined %v %v", NewHandle(testTypeWorker, 1), testTypeWorker), <-events)
+	b.AddMember(NewHandle(testTypeWorker, 2), nil)
+	<-events
+
+	utils.TestAsString(
+		t, 2, "list",
+		fmt.Sprintf("[%v %v]", NewHandle(testTypeWorker, 1), NewHandle(testTypeWorker, 2)),
+		b.ListMembers(testTypeWorker),
+	)
+
+	worker.Close()
+	utils.TestString(t, 3, "left", fmt.Sprintf("left %v %v", NewHandle(testTypeWorker, 1), testTypeWorker), <-events)
+	utils.TestAsString(t, 4, "list", fmt.Sprintf("[%v]", NewHandle(testTypeWorker, 2)), worker.ListMembers(testTypeWorker))
+}
@@ -0,0 +1,34 @@
+package broker
+
+type Priority int8
+
+const (
+	PriorityLow    Priority = -1
+	PriorityNormal Priority = 0
+	PriorityHigh   Priority = 1
+
+	priorityLanes = 3
+)
+
+// WithPriority sets the priority of the message in the broker queue, it overrides
+// CommandDescriptor.GetPriority
+func WithPriority(priority Priority) SendOption {
+	return func(o *sendOptions) {
+		o.priority = &priority
+	}
+}
+
+func (c *controller[Command]) getPriority(cmd Command, options sendOptions) Priority {
+	switch {
+	case options.priority != nil:
+		return *options.priority
+	case c.descriptor.GetPriority != nil:
+		return c.descriptor.GetPriority(cmd)
+	default:
+		return PriorityNormal
+	}
+}
+
+func (p Priority) lane() int {
+	return int(min(max(p, PriorityLow), PriorityHigh) - PriorityLow)
+}
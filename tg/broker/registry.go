@@ -0,0 +1,76 @@
+package broker
+
+import (
+	"errors"
+	"math"
+)
+
+var (
+	ErrDuplicateName = errors.New("member name already in use")
+	ErrEmptyName     = errors.New("member name is empty")
+	ErrNoFreeHandle  = errors.New("no free handle")
+)
+
+// AddNamedMember adds a member with a new handle of the type; the name must be unique and not
+// empty
+func (c *controller[Command]) AddNamedMember(
+	typeID uint32, name string, messageHandler MemberMessageHandler[Command], options ...MemberOption[Command],
+) (IMember[Command], error) {
+	if name == "" {
+		return nil, ErrEmptyName
+	}
+	var (
+		m   *memberWrapper[Command]
+		err = ErrBrokerClosed
+	)
+	c.p.ExecLocked(func() {
+		if _, ok := c.names[name]; ok {
+			err = ErrDuplicateName
+			return
+		}
+		var handle Handle
+		if handle, err = c.allocHandleLocked(typeID); err != nil {
+			return
+		}
+		m = c.addMemberLocked(handle, messageHandler, options...)
+		m.name = name
+		c.names[name] = handle
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.memberEvent(MemberJoined, m.id)
+	return m, nil
+}
+
+func (c *controller[Command]) LookupName(name string) (Handle, bool) {
+	var (
+		handle Handle
+		ok     bool
+	)
+	c.p.ExecLocked(func() {
+		handle, ok = c.names[name]
+	})
+	return handle, ok
+}
+
+func (m *memberWrapper[Command]) LookupName(name string) (Handle, bool) {
+	return m.broker.LookupName(name)
+}
+
+// allocHandleLocked returns the next unused handle of the type, skipping the handles that were
+// added by AddMember
+func (c *controller[Command]) allocHandleLocked(typeID uint32) (Handle, error) {
+	seqID := c.lastSeqIDs[typeID]
+	for range math.MaxUint32 {
+		seqID++
+		if seqID == HandleAny {
+			continue
+		}
+		if handle := NewHandle(typeID, seqID); c.members[handle] == nil {
+			c.lastSeqIDs[typeID] = seqID
+			return handle, nil
+		}
+	}
+	return 0, ErrNoFreeHandle
+}
@@ -0,0 +1,68 @@
+package broker
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/MrReality255/turbo-go/tg/utils"
+)
+
+func TestNamedMembers(t *testing.T) {
+	b := New(testDescriptor, time.Second)
+	defer b.Close()
+
+	b.AddMember(NewHandle(testTypeWorker, 2), nil)
+	first := utils.Must(b.AddNamedMember(testTypeWorker, "first", nil))
+	second := utils.Must(b.AddNamedMember(testTypeWorker, "second", echoHandler))
+
+	_, err := b.AddNamedMember(testTypeClient, "first", nil)
+	utils.TestAsString(t, 1, "duplicate", ErrDuplicateName.Error(), err)
+	_, err = b.AddNamedMember(testTypeWorker, "", nil)
+	utils.TestAsString(t, 1, "empty", ErrEmptyName.Error(), err)
+
+	handle, ok := first.LookupName("second")
+	utils.TestAsString(t, 2, "lookup", fmt.Sprintf("%v true", NewHandle(testTypeWorker, 3)), fmt.Sprintf("%v %v", handle, ok))
+	resp := utils.Must(first.Request(handle, newTestCmd(testTypeWorker, "hello")))
+	utils.TestString(t, 3, "request", "echo: hello", resp.Payload)
+
+	second.Close()
+	_, ok = b.LookupName("second")
+	utils.TestAsString(t, 4, "closed", "false", ok)
+	utils.Must(b.AddNamedMember(testTypeWorker, "second", nil))
+	handle, _ = b.LookupName("second")
+	utils.TestAsString(t, 5, "new handle", fmt.Sprint(NewHandle(testTypeWorker, 4)), handle)
+	handle, _ = b.LookupName("first")
+	utils.TestAsString(t, 6, "first", fmt.Sprint(NewHandle(testTypeWorker, 1)), handle)
+}
+
+func TestReplaceMember(t *testing.T) {
+	var (
+		b        = New(testDescriptor, time.Minute)
+		handle   = NewHandle(testTypeWorker, 1)
+		chAborts = make(chan error, 1)
+		chClosed = make(chan bool)
+	)
+	b.AddMember(NewHandle(testTypeWorker, 2), nil)
+	replaced := utils.Must(b.AddNamedMember(testTypeWorker, "replaced", nil))
+	replaced.RequestMultiple(NewHandle(testTypeWorker, 2), newTestCmd(testTypeWorker, "pending"), func(cmd testCmd, err error) bool {
+		chAborts <- err
+		return true
+	})
+
+	// the replaced member is closed: its name is released and its requests are aborted
+	b.AddMember(handle, echoHandler)
+	_, ok := b.LookupName("replaced")
+	utils.TestAsString(t, 1, "name", "false", ok)
+	utils.TestAsString(t, 2, "aborted", ErrRequestAborted.Error(), <-chAborts)
+
+	go func() {
+		b.Close()
+		close(chClosed)
+	}()
+	select {
+	case <-chClosed:
+	case <-time.After(closeTimeout):
+		t.Fatal("close waits for the workers of the replaced member")
+	}
+}
@@ -1,7 +1,9 @@
 package broker
 
 import (
+	"context"
 	"errors"
+	"iter"
 	"sync"
 	"time"
 
@@ -18,15 +20,25 @@ type IRequestManager[Command ICommand] interface {
 	Abort()
 	Accept(msg Command)
 	Request(req Command) (Command, error)
+	RequestCtx(ctx context.Context, req Command) (Command, error)
 	RequestMultiple(req Command, handler func(responseCmd Command, err error) bool)
+	RequestMultipleCtx(ctx context.Context, req Command, handler func(responseCmd Command, err error) bool)
+	RequestStream(req Command) iter.Seq2[Command, error]
 }
 
 type requestWrapper[Command ICommand] struct {
 	refID         Handle
 	timeout       time.Time
+	hasDeadline   bool
 	handlerLocked func(responseCmd Command, err error) bool
+	chDone        chan bool
+	responses     []Command
+	isDraining    bool
+	stopTimer     func() bool
+	stopWatch     func() bool
 
-	mx sync.Mutex
+	mx     sync.Mutex
+	doneMx sync.Once
 }
 
 type requestManager[Command ICommand] struct {
@@ -35,53 +47,91 @@ type requestManager[Command ICommand] struct {
 	mx             sync.Mutex
 	descriptor     CommandDescriptor[Command]
 	senderFct      func(cmd Command) error
+	sendCtx        func(ctx context.Context, cmd Command) error
 	receiverFct    func(cmd Command)
 	timeout        time.Duration
+	counters       *counters
+	clock          IClock
+	spawn          func(fct func())
 }
 
+type RequestManagerOption[Command ICommand] func(m *requestManager[Command])
+
 func NewRequestManager[Command ICommand](
 	descriptor CommandDescriptor[Command],
 	senderFct func(cmd Command) error,
 	receiverFct func(Cmd Command),
 	timeout time.Duration,
+	options ...RequestManagerOption[Command],
 ) IRequestManager[Command] {
+	m := newRequestManager(descriptor, senderFct, receiverFct, timeout)
+	for _, option := range options {
+		option(m)
+	}
+	return m
+}
+
+// WithRequestClock sets the clock used for the timeouts
+func WithRequestClock[Command ICommand](clock IClock) RequestManagerOption[Command] {
+	return func(m *requestManager[Command]) {
+		m.clock = clock
+	}
+}
+
+func newRequestManager[Command ICommand](
+	descriptor CommandDescriptor[Command],
+	senderFct func(cmd Command) error,
+	receiverFct func(Cmd Command),
+	timeout time.Duration,
+) *requestManager[Command] {
 	return &requestManager[Command]{
 		activeRequests: make(map[Handle]*requestWrapper[Command]),
 		descriptor:     descriptor,
 		senderFct:      senderFct,
 		receiverFct:    receiverFct,
 		timeout:        timeout,
+		clock:          systemClock{},
+		spawn:          goSpawn,
 	}
 }
 
+func goSpawn(fct func()) {
+	go fct()
+}
+
+// Abort fails the pending requests with ErrRequestAborted. The handlers are called
+// synchronously while the manager is locked: they must not use the manager, and the handler
+// of a stream waits until its consumer has taken the error.
 func (m *requestManager[Command]) Abort() {
 	m.mx.Lock()
 	defer m.mx.Unlock()
 	m.isAborted = true
+	if m.counters != nil {
+		m.counters.aborts.Add(int64(len(m.activeRequests)))
+	}
 	for _, request := range m.activeRequests {
 		var dummy Command
+		request.done()
 		request.handlerLocked(dummy, ErrRequestAborted)
 	}
 	m.activeRequests = make(map[Handle]*requestWrapper[Command])
 }
 
 func (m *requestManager[Command]) Accept(msg Command) {
-	refID := m.descriptor.GetRef(msg)
-
-	m.mx.Lock()
-	defer m.mx.Unlock()
-	rec := m.activeRequests[refID]
-	if rec == nil {
-		go m.receiverFct(msg)
-		return
+	if !m.acceptResponse(msg) {
+		m.spawn(func() {
+			m.receiverFct(msg)
+		})
 	}
-
-	go m.handleResponse(msg, refID, rec)
 }
 
 func (m *requestManager[Command]) Request(req Command) (Command, error) {
+	return m.RequestCtx(context.Background(), req)
+}
+
+func (m *requestManager[Command]) RequestCtx(ctx context.Context, req Command) (Command, error) {
 	ch := make(chan utils.ItemWithErr[Command], 1)
-	go m.RequestMultiple(req, func(responseCmd Command, err error) bool {
+	go m.RequestMultipleCtx(ctx, req, func(responseCmd Command, err error) bool {
 		response := utils.ItemWithErr[Command]{Data: responseCmd, Err: err}
 		ch <- response
 		close(ch)
@@ -94,6 +144,14 @@ func (m *requestManager[Command]) Request(req Command) (Command, error) {
 func (m *requestManager[Command]) RequestMultiple(
 	req Command,
 	handler func(responseCmd Command, err error) bool,
+) {
+	m.RequestMultipleCtx(context.Background(), req, handler)
+}
+
+func (m *requestManager[Command]) RequestMultipleCtx(
+	ctx context.Context,
+	req Command,
+	handler func(responseCmd Command, err error) bool,
 ) {
 	var (
 		dummy  Command
@@ -103,47 +161,131 @@ func (m *requestManager[Command]) RequestMultiple(
 		newRec = &requestWrapper[Command]{
 			refID:         handle,
 			handlerLocked: handler,
-			timeout:       time.Now().Add(m.timeout),
+			timeout:       m.clock.Now().Add(m.timeout),
+			chDone:        make(chan bool),
 		}
 	)
 
+	// a deadline of the context replaces the default timeout
+	if deadline, ok := ctx.Deadline(); ok {
+		newRec.timeout = deadline
+		newRec.hasDeadline = true
+	}
+
 	utils.ExecLocked(&m.mx, func() {
 		switch {
 		case m.isAborted:
-			go func() {
+			m.spawn(func() {
 				_ = handler(dummy, ErrHandleConflict)
-			}()
+			})
 			isDone = true
 			return
 		case m.activeRequests[handle] != nil:
-			go func() {
+			m.spawn(func() {
 				_ = handler(dummy, ErrHandleConflict)
-			}()
+			})
+			isDone = true
+			return
+		case ctx.Err() != nil:
+			m.spawn(func() {
+				_ = handler(dummy, ctx.Err())
+			})
 			isDone = true
 			return
 		}
 		m.activeRequests[handle] = newRec
+
+		// the timers are released as soon as the request is done, no goroutine waits for them
+		newRec.stopTimer = m.clock.AfterFunc(newRec.timeout.Sub(m.clock.Now()), func() {
+			m.checkTimeout(handle, newRec)
+		})
+		if ctx.Done() != nil {
+			newRec.stopWatch = context.AfterFunc(ctx, func() {
+				m.cancelRequest(ctx, handle, newRec)
+			})
+		}
 	})
 	if isDone {
 		return
 	}
-	go m.checkTimeout(handle, m.timeout+time.Millisecond, newRec)
-	err := m.senderFct(req)
+	err := m.send(ctx, req)
 	if err != nil {
 		utils.ExecLocked(&m.mx, func() {
 			var dummy Command
-			delete(m.activeRequests, handle)
-			go func() {
+			m.removeActiveRequest(handle, newRec, true)
+			m.spawn(func() {
 				_ = handler(dummy, err)
-			}()
+			})
 		})
 	}
 }
 
-func (m *requestManager[Command]) checkTimeout(
-	handle Handle, sleepTime time.Duration, ref *requestWrapper[Command],
-) {
-	time.Sleep(sleepTime)
+func (m *requestManager[Command]) send(ctx context.Context, req Command) error {
+	if m.sendCtx != nil {
+		return m.sendCtx(ctx, req)
+	}
+	return m.senderFct(req)
+}
+
+func (m *requestManager[Command]) isActive(refID Handle) bool {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	return m.activeRequests[refID] != nil
+}
+
+// acceptResponse passes the message to the matching active request, if there is one
+func (m *requestManager[Command]) acceptResponse(msg Command) bool {
+	refID := m.descriptor.GetRef(msg)
+
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	rec := m.activeRequests[refID]
+	if rec == nil {
+		return false
+	}
+
+	// the responses of a request are handled one after the other, in the order of arrival
+	rec.responses = append(rec.responses, msg)
+	if !rec.isDraining {
+		rec.isDraining = true
+		m.spawn(func() {
+			m.drainResponses(refID, rec)
+		})
+	}
+	return true
+}
+
+func (m *requestManager[Command]) drainResponses(id Handle, rec *requestWrapper[Command]) {
+	for {
+		var (
+			msg Command
+			ok  bool
+		)
+		utils.ExecLocked(&m.mx, func() {
+			if len(rec.responses) == 0 {
+				rec.isDraining = false
+				return
+			}
+			msg, ok = rec.responses[0], true
+			rec.responses = rec.responses[1:]
+		})
+		if !ok {
+			return
+		}
+		m.handleResponse(msg, id, rec)
+	}
+}
+
+// RequestStream yields the responses to the request until the consumer stops, until the
+// descriptor marks a response as the last one, or until the request fails; the error is
+// yielded as the last item
+func (m *requestManager[Command]) RequestStream(req Command) iter.Seq2[Command, error] {
+	return streamRequest(m.descriptor.IsLast, func(ctx context.Context, handler RequestHandler[Command]) {
+		m.RequestMultipleCtx(ctx, req, handler)
+	})
+}
+
+func (m *requestManager[Command]) checkTimeout(handle Handle, ref *requestWrapper[Command]) {
 	m.mx.Lock()
 	defer m.mx.Unlock()
 	rec, ok := m.activeRequests[handle]
@@ -151,18 +293,40 @@ func (m *requestManager[Command]) checkTimeout(
 		return
 	}
 
-	if n := time.Now(); n.Before(rec.timeout) {
-		go m.checkTimeout(handle, rec.timeout.Sub(n)+time.Millisecond, ref)
+	if n := m.clock.Now(); n.Before(rec.timeout) {
+		rec.stopTimer = m.clock.AfterFunc(rec.timeout.Sub(n), func() {
+			m.checkTimeout(handle, ref)
+		})
 		return
 	}
 
 	m.removeActiveRequest(rec.refID, rec, true)
-	go func() {
+	if m.counters != nil {
+		m.counters.timeouts.Add(1)
+	}
+	m.spawn(func() {
 		var dummy Command
 		rec.mx.Lock()
 		defer rec.mx.Unlock()
-		rec.handlerLocked(dummy, ErrRequestTimeout)
-	}()
+		rec.handlerLocked(dummy, utils.IfThen[error](rec.hasDeadline, context.DeadlineExceeded, ErrRequestTimeout))
+	})
+}
+
+func (m *requestManager[Command]) cancelRequest(
+	ctx context.Context, handle Handle, ref *requestWrapper[Command],
+) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	if m.activeRequests[handle] != ref {
+		return
+	}
+	m.removeActiveRequest(handle, ref, true)
+	m.spawn(func() {
+		var dummy Command
+		ref.mx.Lock()
+		defer ref.mx.Unlock()
+		ref.handlerLocked(dummy, ctx.Err())
+	})
 }
 
 func (m *requestManager[Command]) handleResponse(
@@ -170,14 +334,24 @@ func (m *requestManager[Command]) handleResponse(
 ) {
 	rec.mx.Lock()
 	defer rec.mx.Unlock()
+
+	// the request has already finished
+	select {
+	case <-rec.chDone:
+		return
+	default:
+	}
+
 	isDone := rec.handlerLocked(msg, nil)
 	if isDone {
-		go m.removeActiveRequest(id, rec, false)
+		m.removeActiveRequest(id, rec, false)
 		return
 	}
 
 	// not done yet? fix the timeout
-	rec.timeout = time.Now().Add(m.timeout)
+	if !rec.hasDeadline {
+		rec.timeout = m.clock.Now().Add(m.timeout)
+	}
 }
 
 func (m *requestManager[Command]) removeActiveRequest(
@@ -189,5 +363,49 @@ func (m *requestManager[Command]) removeActiveRequest(
 	}
 	if m.activeRequests[id] == rec {
 		delete(m.activeRequests, id)
+		rec.done()
+	}
+}
+
+// done must be called with the lock of the request manager held
+func (r *requestWrapper[Command]) done() {
+	r.doneMx.Do(func() {
+		close(r.chDone)
+		if r.stopTimer != nil {
+			r.stopTimer()
+		}
+		if r.stopWatch != nil {
+			r.stopWatch()
+		}
+	})
+}
+
+func streamRequest[Command ICommand](
+	isLast func(cmd Command) bool,
+	requestFct func(ctx context.Context, handler RequestHandler[Command]),
+) iter.Seq2[Command, error] {
+	isLastResponse := func(cmd Command, err error) bool {
+		return err == nil && isLast != nil && isLast(cmd)
+	}
+
+	return func(yield func(Command, error) bool) {
+		// cancelling the context ends the request once the consumer stops
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		ch := make(chan utils.ItemWithErr[Command])
+		requestFct(ctx, func(cmd Command, err error) bool {
+			select {
+			case ch <- utils.DataOrErr(cmd, err):
+			case <-ctx.Done():
+			}
+			return isLastResponse(cmd, err)
+		})
+		for {
+			r := <-ch
+			if !yield(r.Data, r.Err) || r.Err != nil || isLastResponse(r.Data, r.Err) {
+				return
+			}
+		}
 	}
 }
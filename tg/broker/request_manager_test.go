@@ -1,8 +1,10 @@
 package broker
 
 import (
+	"context"
 	"fmt"
 	"github.com/MrReality255/turbo-go/tg/utils"
+	"runtime"
 	"testing"
 	"time"
 )
@@ -40,3 +42,81 @@ func TestRequestManager(t *testing.T) {
 	utils.TestAsString(t, 1, "request", "13363 <nil>", fmt.Sprintf("%v %v", r, err))
 
 }
+
+func TestRequestManagerCtx(t *testing.T) {
+	m := NewRequestManager[uint32](
+		CommandDescriptor[uint32]{
+			GetID: func(cmd uint32) Handle {
+				return Handle(cmd & 0xFF)
+			},
+			GetRef: func(cmd uint32) Handle {
+				return Handle(cmd & 0xFF)
+			},
+		},
+		func(cmd uint32) error {
+			// never answered
+			return nil
+		},
+		func(Cmd uint32) {},
+		time.Minute,
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	_, err := m.RequestCtx(ctx, 0x01)
+	utils.TestAsString(t, 1, "cancel", "context canceled", err)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = m.RequestCtx(ctx, 0x01)
+	utils.TestAsString(t, 2, "deadline", "context deadline exceeded", err)
+
+	// the cancelled requests are no longer active
+	var (
+		rm    = m.(*requestManager[uint32])
+		count int
+	)
+	utils.ExecLocked(&rm.mx, func() {
+		count = len(rm.activeRequests)
+	})
+	utils.TestAsString(t, 3, "active", "0", count)
+}
+
+// BenchmarkInFlightRequests keeps many requests of a broker waiting for their timeout. It only
+// uses the API of the first broker version, so the numbers compare with older commits.
+func BenchmarkInFlightRequests(b *testing.B) {
+	const inFlight = 10000
+
+	var (
+		maxGoroutines int
+		workerID      = NewHandle(2, 1)
+		descriptor    = CommandDescriptor[Handle]{
+			GetID: func(cmd Handle) Handle {
+				return cmd
+			},
+			GetRef: func(cmd Handle) Handle {
+				return 0
+			},
+		}
+		ignore = func(sender Handle, msg Handle, member IMember[Handle]) {}
+	)
+	b.ReportAllocs()
+	for b.Loop() {
+		br := New(descriptor, time.Minute)
+		br.AddMember(workerID, ignore)
+		client := br.AddMember(NewHandle(1, 1), ignore)
+
+		before := runtime.NumGoroutine()
+		for i := range uint32(inFlight) {
+			client.RequestMultiple(workerID, NewHandle(1, i+1), func(cmd Handle, err error) bool {
+				return true
+			})
+		}
+		maxGoroutines = max(maxGoroutines, runtime.NumGoroutine()-before)
+		br.Close()
+	}
+	b.ReportMetric(float64(maxGoroutines), "goroutines")
+}
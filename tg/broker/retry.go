@@ -0,0 +1,95 @@
+package broker
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+
+	"github.com/MrReality255/turbo-go/tg/utils"
+)
+
+// RetryPolicy repeats a failed request with a new command created by CommandDescriptor.Reissue.
+// The delay before the n-th retry is Backoff * 2^(n-1), limited by MaxBackoff and reduced by a
+// random part of up to Jitter (0..1) of it.
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	Jitter      float64
+	// Retryable are the errors that are retried, ErrRequestTimeout if empty
+	Retryable []error
+}
+
+type RequestOption func(o *requestOptions)
+
+type requestOptions struct {
+	retry *RetryPolicy
+}
+
+func WithRetry(policy RetryPolicy) RequestOption {
+	return func(o *requestOptions) {
+		o.retry = &policy
+	}
+}
+
+func newRequestOptions(options []RequestOption) requestOptions {
+	var o requestOptions
+	for _, option := range options {
+		option(&o)
+	}
+	return o
+}
+
+func (m *memberWrapper[Command]) requestWithRetry(
+	ctx context.Context, receiver Handle, cmd Command, policy RetryPolicy,
+) (Command, error) {
+	if m.descriptor.Reissue == nil {
+		panic("retrying a request requires CommandDescriptor.Reissue")
+	}
+	retryable := policy.Retryable
+	if len(retryable) == 0 {
+		retryable = []error{ErrRequestTimeout}
+	}
+
+	for attempt := 1; ; attempt++ {
+		resp, err := m.requestOnce(ctx, receiver, cmd)
+		if err == nil || attempt >= policy.MaxAttempts || !utils.IsErr(err, retryable...) {
+			return resp, err
+		}
+		if errWait := m.wait(ctx, policy.delay(attempt)); errWait != nil {
+			return resp, errWait
+		}
+		// the failed request may still be answered, the next one needs its own ID
+		cmd = m.descriptor.Reissue(cmd)
+	}
+}
+
+// wait sleeps on the clock of the broker, it fails if the context is done or the broker shuts
+// down first
+func (m *memberWrapper[Command]) wait(ctx context.Context, d time.Duration) error {
+	chDone := make(chan bool)
+	stop := m.broker.clock.AfterFunc(d, func() {
+		close(chDone)
+	})
+	select {
+	case <-chDone:
+		return nil
+	case <-ctx.Done():
+		stop()
+		return ctx.Err()
+	case <-m.broker.chClosing:
+		stop()
+		return ErrBrokerClosed
+	}
+}
+
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	d := p.Backoff << min(attempt-1, 30)
+	if p.MaxBackoff > 0 && (d > p.MaxBackoff || d < 0) {
+		d = p.MaxBackoff
+	}
+	if p.Jitter > 0 {
+		d -= time.Duration(float64(d) * min(p.Jitter, 1) * rand.Float64())
+	}
+	return d
+}
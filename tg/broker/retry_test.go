@@ -0,0 +1,77 @@
+package broker
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/MrReality255/turbo-go/tg/utils"
+)
+
+func TestRetry(t *testing.T) {
+	descriptor := testDescriptor
+	descriptor.Reissue = func(cmd testCmd) testCmd {
+		cmd.ID = NewHandle(cmd.ID.GetTypeID(), testSeq.Add(1))
+		return cmd
+	}
+	var (
+		b        = New(descriptor, 20*time.Millisecond)
+		workerID = NewHandle(testTypeWorker, 1)
+		client   = b.AddMember(NewHandle(testTypeClient, 1), nil)
+		attempts atomic.Int32
+		ids      = utils.NewStringList(16, true)
+		policy   = RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond, Jitter: 0.5}
+	)
+	defer b.Close()
+
+	// the first two requests are lost
+	b.AddMember(workerID, func(sender Handle, msg testCmd, member IMember[testCmd]) {
+		ids.Add(fmt.Sprint(msg.ID))
+		if attempts.Add(1) > 2 {
+			echoHandler(sender, msg, member)
+		}
+	})
+
+	resp, err := client.Request(workerID, newTestCmd(testTypeWorker, "retried"), WithRetry(policy))
+	utils.TestAsString(t, 1, "response", "echo: retried <nil>", fmt.Sprintf("%v %v", resp.Payload, err))
+	utils.TestAsString(t, 2, "fresh IDs", "3 3", fmt.Sprintf("%v %v", attempts.Load(), len(ids.Content())))
+
+	attempts.Store(0)
+	_, err = client.Request(workerID, newTestCmd(testTypeWorker, "exhausted"), WithRetry(RetryPolicy{MaxAttempts: 2}))
+	utils.TestAsString(t, 3, "exhausted", fmt.Sprintf("%v 2", ErrRequestTimeout), fmt.Sprintf("%v %v", err, attempts.Load()))
+
+	_, err = client.Request(NewHandle(testTypeWorker, 2), newTestCmd(testTypeWorker, "unknown"), WithRetry(policy))
+	utils.TestAsString(t, 4, "not retryable", ErrUnknownReceiver.Error(), err)
+}
+
+func TestRetryDelay(t *testing.T) {
+	p := RetryPolicy{Backoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	utils.TestAsString(t, 1, "delay", "[10ms 20ms 40ms 50ms]", []time.Duration{p.delay(1), p.delay(2), p.delay(3), p.delay(4)})
+}
+
+func TestRetryShutdown(t *testing.T) {
+	descriptor := testDescriptor
+	descriptor.Reissue = func(cmd testCmd) testCmd {
+		return cmd
+	}
+	var (
+		b        = New(descriptor, time.Second)
+		client   = b.AddMember(NewHandle(testTypeClient, 1), nil)
+		policy   = RetryPolicy{MaxAttempts: 3, Backoff: time.Minute, Retryable: []error{ErrUnknownReceiver}}
+		chResult = make(chan error, 1)
+	)
+
+	// the retry waits for its backoff until the broker is closed
+	go func() {
+		_, err := client.Request(NewHandle(testTypeWorker, 1), newTestCmd(testTypeWorker, "unknown"), WithRetry(policy))
+		chResult <- err
+	}()
+	b.Close()
+	select {
+	case err := <-chResult:
+		utils.TestAsString(t, 1, "closed", ErrBrokerClosed.Error(), err)
+	case <-time.After(closeTimeout):
+		t.Fatal("the retry still waits after the shutdown")
+	}
+}
@@ -0,0 +1,55 @@
+package broker
+
+import (
+	"time"
+
+	"github.com/MrReality255/turbo-go/tg/log"
+)
+
+// IScheduled is a message that is sent later
+type IScheduled interface {
+	// Cancel reports whether the message was still pending
+	Cancel() bool
+	At() time.Time
+}
+
+type scheduledMessage struct {
+	stop func() bool
+	at   time.Time
+}
+
+func (m *memberWrapper[Command]) SendAfter(
+	receiver Handle, cmd Command, delay time.Duration, options ...SendOption,
+) (IScheduled, error) {
+	return m.SendAt(receiver, cmd, m.broker.clock.Now().Add(delay), options...)
+}
+
+func (m *memberWrapper[Command]) SendAt(
+	receiver Handle, cmd Command, at time.Time, options ...SendOption,
+) (IScheduled, error) {
+	return m.broker.sendAt(m.id, receiver, cmd, at, newSendOptions(options))
+}
+
+// sendAt schedules the message; messages still pending when the broker is closed are dropped
+func (c *controller[Command]) sendAt(
+	sender Handle, receiver Handle, cmd Command, at time.Time, options sendOptions,
+) (IScheduled, error) {
+	if c.isClosing.Load() {
+		return nil, ErrBrokerClosed
+	}
+	stop := c.clock.AfterFunc(at.Sub(c.clock.Now()), func() {
+		// a blocking send must not delay the other timers
+		c.spawn(func() {
+			log.IfError("unable to send scheduled message: %v", c.send(sender, receiver, cmd, options))
+		})
+	})
+	return &scheduledMessage{stop: stop, at: at}, nil
+}
+
+func (s *scheduledMessage) Cancel() bool {
+	return s.stop()
+}
+
+func (s *scheduledMessage) At() time.Time {
+	return s.at
+}
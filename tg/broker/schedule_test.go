@@ -0,0 +1,35 @@
+package broker
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/MrReality255/turbo-go/tg/utils"
+)
+
+func TestSendAfter(t *testing.T) {
+	var (
+		b        = New(testDescriptor, time.Second)
+		received = make(chan string, 3)
+		workerID = NewHandle(testTypeWorker, 1)
+		client   = b.AddMember(NewHandle(testTypeClient, 1), nil)
+	)
+	b.AddMember(workerID, func(sender Handle, msg testCmd, member IMember[testCmd]) {
+		received <- msg.Payload
+	})
+
+	utils.Must(client.SendAfter(workerID, newTestCmd(testTypeWorker, "later"), 30*time.Millisecond))
+	utils.Must(client.SendAt(workerID, newTestCmd(testTypeWorker, "sooner"), time.Now().Add(5*time.Millisecond)))
+	cancelled := utils.Must(client.SendAfter(workerID, newTestCmd(testTypeWorker, "cancelled"), 10*time.Millisecond))
+	utils.TestAsString(t, 1, "cancel", "true false", fmt.Sprintf("%v %v", cancelled.Cancel(), cancelled.Cancel()))
+
+	utils.TestString(t, 2, "first", "sooner", <-received)
+	utils.TestString(t, 3, "second", "later", <-received)
+
+	pending := utils.Must(client.SendAfter(workerID, newTestCmd(testTypeWorker, "pending"), time.Hour))
+	b.Close()
+	utils.TestAsString(t, 4, "dropped", "false", pending.Cancel())
+	_, err := client.SendAfter(workerID, newTestCmd(testTypeWorker, "closed"), time.Millisecond)
+	utils.TestAsString(t, 5, "closed", ErrBrokerClosed.Error(), err)
+}
@@ -0,0 +1,193 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	drainInterval = 5 * time.Millisecond
+	closeTimeout  = 5 * time.Second
+)
+
+// worker runs the handlers of a member on its own goroutine
+type worker[Command ICommand] struct {
+	member     *memberWrapper[Command]
+	isReleased atomic.Bool
+}
+
+// handlerMember is passed to a handler running on a worker
+type handlerMember[Command ICommand] struct {
+	IMember[Command]
+	worker *worker[Command]
+}
+
+// taskGroup counts the workers and the goroutines running request handlers; unlike a
+// sync.WaitGroup it may grow while someone waits for it
+type taskGroup struct {
+	mx     sync.Mutex
+	count  int
+	chIdle chan bool
+}
+
+// Shutdown stops accepting new messages and processes the queued messages until the context is
+// done, the responses to pending requests are still accepted meanwhile. Then the pending
+// requests are aborted, and the broker is closed once the running handlers and request handlers
+// have finished, or once the context is done. A handler shuts the broker down with
+// ShutdownBroker of its member, which neither waits for that handler nor for the messages
+// queued behind it. A simulation is not drained, it has to be run before the shutdown.
+func (c *controller[Command]) Shutdown(ctx context.Context) error {
+	return c.shutdown(ctx, nil)
+}
+
+// ShutdownBroker shuts the broker down; called by a handler, the shutdown doesn't wait for it
+func (m *memberWrapper[Command]) ShutdownBroker(ctx context.Context) error {
+	return m.broker.Shutdown(ctx)
+}
+
+// ShutdownBroker shuts the broker down without waiting for the running handler
+func (h *handlerMember[Command]) ShutdownBroker(ctx context.Context) error {
+	return h.worker.member.broker.shutdown(ctx, h.worker)
+}
+
+// shutdown doesn't wait for the handler running on self, if there is one
+func (c *controller[Command]) shutdown(ctx context.Context, self *worker[Command]) error {
+	if !c.isClosing.CompareAndSwap(false, true) {
+		return ErrBrokerClosed
+	}
+	close(c.chClosing)
+
+	var errDrain error
+	if c.sim == nil {
+		errDrain = c.drain(ctx, self)
+	}
+	for _, m := range c.allMembers() {
+		m.abortRequests()
+	}
+	errClose := c.p.Close()
+
+	// the members can't change anymore once the runner is closed
+	for _, m := range c.members {
+		m.mailbox.close()
+	}
+	if self != nil {
+		c.releaseWorker(self)
+	}
+	if err := c.tasks.wait(ctx); err != nil && errDrain == nil {
+		errDrain = err
+	}
+	return errors.Join(errDrain, errClose)
+}
+
+// drain waits until the queue and the mailboxes of all members but the calling one are empty
+func (c *controller[Command]) drain(ctx context.Context, self *worker[Command]) error {
+	ticker := time.NewTicker(drainInterval)
+	defer ticker.Stop()
+
+	for !c.isIdle(self) {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+func (c *controller[Command]) isIdle(self *worker[Command]) bool {
+	if c.pending.Load() > 0 {
+		return false
+	}
+	for _, m := range c.allMembers() {
+		if m.inFlight.Load() > 0 && (self == nil || m != self.member) {
+			return false
+		}
+	}
+	return true
+}
+
+// isResponse reports whether the message answers a pending request of its receiver
+func (c *controller[Command]) isResponse(sender Handle, receiver Handle, cmd Command) bool {
+	var m *memberWrapper[Command]
+	c.p.ExecLocked(func() {
+		m = c.members[receiver]
+	})
+	return m != nil && m.expectsResponse(sender, cmd)
+}
+
+// releaseWorker marks the worker as finished, only once
+func (c *controller[Command]) releaseWorker(w *worker[Command]) {
+	if w.isReleased.CompareAndSwap(false, true) {
+		c.tasks.done()
+	}
+}
+
+// forHandler returns the member that is passed to the handler of a message
+func (m *memberWrapper[Command]) forHandler(sp *traceSpan, w *worker[Command]) IMember[Command] {
+	if w == nil {
+		return m.withSpan(sp)
+	}
+	return &handlerMember[Command]{IMember: m.withSpan(sp), worker: w}
+}
+
+func (g *taskGroup) add() {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+	if g.count == 0 {
+		g.chIdle = make(chan bool)
+	}
+	g.count++
+}
+
+func (g *taskGroup) done() {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+	g.count--
+	if g.count == 0 {
+		close(g.chIdle)
+	}
+}
+
+// wait waits until no task is running, or until the context is done
+func (g *taskGroup) wait(ctx context.Context) error {
+	g.mx.Lock()
+	if g.count == 0 {
+		g.mx.Unlock()
+		return nil
+	}
+	chIdle := g.chIdle
+	g.mx.Unlock()
+
+	select {
+	case <-chIdle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *controller[Command]) allMembers() []*memberWrapper[Command] {
+	var result []*memberWrapper[Command]
+	c.p.ExecLocked(func() {
+		for _, m := range c.members {
+			result = append(result, m)
+		}
+	})
+	return result
+}
+
+func (m *memberWrapper[Command]) abortRequests() {
+	m.mx.Lock()
+	managers := make([]*requestManager[Command], 0, len(m.reqManager))
+	for _, rm := range m.reqManager {
+		managers = append(managers, rm)
+	}
+	m.mx.Unlock()
+
+	for _, rm := range managers {
+		rm.Abort()
+	}
+}
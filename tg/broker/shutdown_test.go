@@ -0,0 +1,108 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/MrReality255/turbo-go/tg/utils"
+)
+
+func TestShutdown(t *testing.T) {
+	var (
+		b        = New(testDescriptor, time.Minute)
+		workerID = NewHandle(testTypeWorker, 1)
+		client   = b.AddMember(NewHandle(testTypeClient, 1), nil)
+		handled  = utils.NewStringList(16, false)
+		// the handler is held until the shutdown has started
+		chRelease = make(chan bool)
+	)
+	b.AddMember(workerID, func(sender Handle, msg testCmd, member IMember[testCmd]) {
+		<-chRelease
+		handled.Add(msg.Payload)
+		if msg.Payload == "request" {
+			utils.MustSucceed(member.Send(sender, msg.reply("answered while draining")))
+		}
+	})
+	b.AddMember(NewHandle(testTypeWorker, 2), nil)
+
+	for i := range 3 {
+		utils.MustSucceed(client.Send(workerID, newTestCmd(testTypeWorker, fmt.Sprint(i))))
+	}
+	chResponse := make(chan string, 2)
+	handler := func(cmd testCmd, err error) bool {
+		chResponse <- fmt.Sprintf("%v %v", cmd.Payload, err)
+		return true
+	}
+	client.RequestMultiple(workerID, newTestCmd(testTypeWorker, "request"), handler)
+	client.RequestMultiple(NewHandle(testTypeWorker, 2), newTestCmd(testTypeWorker, "never answered"), handler)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	chShutdown := make(chan error, 1)
+	go func() {
+		chShutdown <- b.Shutdown(ctx)
+	}()
+	waitUntil(t, "shutdown started", b.(*controller[testCmd]).isClosing.Load)
+	close(chRelease)
+	utils.TestAsString(t, 1, "shutdown", "<nil>", <-chShutdown)
+	utils.TestAsString(t, 2, "drained", "[0 1 2 request]", handled.Content())
+	utils.TestAsString(t, 3, "answered", "answered while draining <nil>", <-chResponse)
+	utils.TestAsString(t, 4, "aborted", " "+ErrRequestAborted.Error(), <-chResponse)
+	utils.TestAsString(t, 5, "closed", ErrBrokerClosed.Error(), client.Send(workerID, newTestCmd(testTypeWorker, "late")))
+}
+
+func TestCloseFromHandler(t *testing.T) {
+	var (
+		b        = New(testDescriptor, time.Minute)
+		workerID = NewHandle(testTypeWorker, 1)
+		chClosed = make(chan bool)
+	)
+	b.AddMember(workerID, func(sender Handle, msg testCmd, member IMember[testCmd]) {
+		utils.MustSucceed(member.ShutdownBroker(context.Background()))
+		close(chClosed)
+	})
+
+	client := b.AddMember(NewHandle(testTypeClient, 1), nil)
+	utils.MustSucceed(client.Send(workerID, newTestCmd(testTypeWorker, "close")))
+	utils.MustSucceed(client.Send(workerID, newTestCmd(testTypeWorker, "queued behind")))
+	select {
+	case <-chClosed:
+	case <-time.After(closeTimeout):
+		t.Fatal("close from a handler deadlocked")
+	}
+	utils.TestAsString(t, 1, "closed", ErrBrokerClosed.Error(), client.Send(workerID, newTestCmd(testTypeWorker, "late")))
+}
+
+func TestCloseWaitsForRequestHandlers(t *testing.T) {
+	var (
+		b          = New(testDescriptor, time.Minute)
+		workerID   = NewHandle(testTypeWorker, 1)
+		client     = b.AddMember(NewHandle(testTypeClient, 1), nil)
+		chStarted  = make(chan bool)
+		chRelease  = make(chan bool)
+		isFinished atomic.Bool
+	)
+	b.AddMember(workerID, echoHandler)
+	client.RequestMultiple(workerID, newTestCmd(testTypeWorker, "slow"), func(cmd testCmd, err error) bool {
+		// the shutdown aborts the request while its response is still being handled
+		if err != nil {
+			return true
+		}
+		close(chStarted)
+		<-chRelease
+		isFinished.Store(true)
+		return true
+	})
+	<-chStarted
+
+	// the request handler is held until the shutdown has started
+	go func() {
+		waitUntil(t, "shutdown started", b.(*controller[testCmd]).isClosing.Load)
+		close(chRelease)
+	}()
+	b.Close()
+	utils.TestAsString(t, 1, "finished", "true", isFinished.Load())
+}
@@ -0,0 +1,94 @@
+package broker
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Simulation runs a broker on a single goroutine: the dispatch of queued messages, the handler
+// calls and the request callbacks are steps, which only run when the simulation is stepped.
+// The order of the ready steps is picked by a seeded random generator, so the same seed gives
+// the same interleaving in every run. Handlers must not block, requests have to use
+// RequestMultiple, and the queues should be large enough to never block.
+type Simulation struct {
+	mx    sync.Mutex
+	rnd   *rand.Rand
+	tasks []func()
+	clock *VirtualClock
+}
+
+func NewSimulation(seed int64, start time.Time) *Simulation {
+	return &Simulation{
+		rnd:   rand.New(rand.NewSource(seed)),
+		clock: NewVirtualClock(start),
+	}
+}
+
+// WithSimulation runs the broker in the simulation, using its virtual clock
+func WithSimulation[Command ICommand](sim *Simulation) Option[Command] {
+	return func(c *controller[Command]) {
+		c.sim = sim
+	}
+}
+
+// WithRequestSimulation runs the callbacks of a request manager in the simulation, using its
+// virtual clock for the timeouts
+func WithRequestSimulation[Command ICommand](sim *Simulation) RequestManagerOption[Command] {
+	return func(m *requestManager[Command]) {
+		m.clock = sim.clock
+		m.spawn = sim.post
+	}
+}
+
+func (s *Simulation) Clock() *VirtualClock {
+	return s.clock
+}
+
+// Step runs one of the ready steps, it reports whether there was one
+func (s *Simulation) Step() bool {
+	var task func()
+	s.mx.Lock()
+	if len(s.tasks) > 0 {
+		i := s.rnd.Intn(len(s.tasks))
+		task = s.tasks[i]
+		s.tasks = append(s.tasks[:i], s.tasks[i+1:]...)
+	}
+	s.mx.Unlock()
+
+	if task == nil {
+		return false
+	}
+	task()
+	return true
+}
+
+// Run steps until no step is ready and returns the number of steps
+func (s *Simulation) Run() int {
+	count := 0
+	for s.Step() {
+		count++
+	}
+	return count
+}
+
+// Advance moves the virtual clock forward; the timers fire in order, and all steps that are
+// ready before a timer fires run first
+func (s *Simulation) Advance(d time.Duration) {
+	target := s.clock.Now().Add(d)
+	for {
+		s.Run()
+		entry := s.clock.next(target)
+		if entry == nil {
+			break
+		}
+		entry.fct()
+	}
+	s.Run()
+}
+
+func (s *Simulation) post(task func()) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.tasks = append(s.tasks, task)
+}
@@ -0,0 +1,98 @@
+package broker
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MrReality255/turbo-go/tg/utils"
+)
+
+func TestSimulation(t *testing.T) {
+	var (
+		sim      = NewSimulation(1, time.Unix(0, 0))
+		b        = New(testDescriptor, time.Second, WithSimulation[testCmd](sim))
+		client   = b.AddMember(NewHandle(testTypeClient, 1), nil)
+		workerID = NewHandle(testTypeWorker, 1)
+		results  = utils.NewStringList(16, false)
+	)
+	defer b.Close()
+
+	b.AddMember(workerID, echoHandler)
+	b.AddMember(NewHandle(testTypeWorker, 2), nil)
+	handler := func(cmd testCmd, err error) bool {
+		results.Addf("%v %v", cmd.Payload, err)
+		return true
+	}
+
+	client.RequestMultiple(workerID, newTestCmd(testTypeWorker, "ping"), handler)
+	client.RequestMultiple(NewHandle(testTypeWorker, 2), newTestCmd(testTypeWorker, "lost"), handler)
+	utils.TestAsString(t, 1, "nothing delivered", "[]", results.Content())
+
+	sim.Run()
+	utils.TestAsString(t, 2, "response", "[echo: ping <nil>]", results.Content())
+
+	sim.Advance(999 * time.Millisecond)
+	utils.TestAsString(t, 3, "before timeout", "1", len(results.Content()))
+	sim.Advance(time.Millisecond)
+	utils.TestAsString(t, 4, "timeout", "[echo: ping <nil>  request timeout]", results.Content())
+}
+
+func TestSimulationOrder(t *testing.T) {
+	run := func(seed int64) string {
+		var (
+			sim      = NewSimulation(seed, time.Unix(0, 0))
+			b        = New(testDescriptor, time.Second, WithSimulation[testCmd](sim))
+			received = utils.NewStringList(64, false)
+		)
+		defer b.Close()
+
+		for i := range 3 {
+			b.AddMember(NewHandle(testTypeWorker, uint32(i+1)), func(sender Handle, msg testCmd, member IMember[testCmd]) {
+				received.Addf("%v:%v", i+1, msg.Payload)
+			}).Subscribe(testTypeEvent)
+		}
+		client := b.AddMember(NewHandle(testTypeClient, 1), nil)
+		for i := range 3 {
+			utils.MustSucceed(client.Send(HandleAny, newTestCmd(testTypeEvent, fmt.Sprint(i))))
+		}
+		sim.Run()
+		return received.Join(",")
+	}
+
+	utils.TestString(t, 1, "same seed", run(7), run(7))
+	utils.TestAsString(t, 2, "all delivered", "9", len(strings.Split(run(8), ",")))
+}
+
+func TestRequestManagerSimulation(t *testing.T) {
+	var (
+		sim    = NewSimulation(1, time.Unix(0, 0))
+		result error
+	)
+	m := NewRequestManager[uint32](
+		CommandDescriptor[uint32]{
+			GetID: func(cmd uint32) Handle {
+				return Handle(cmd)
+			},
+			GetRef: func(cmd uint32) Handle {
+				return Handle(cmd)
+			},
+		},
+		func(cmd uint32) error {
+			return nil
+		},
+		func(cmd uint32) {},
+		time.Minute,
+		WithRequestSimulation[uint32](sim),
+	)
+
+	m.RequestMultiple(1, func(responseCmd uint32, err error) bool {
+		result = err
+		return true
+	})
+	sim.Advance(time.Minute - time.Second)
+	utils.TestAsString(t, 1, "pending", "<nil>", result)
+	sim.Advance(time.Second)
+	utils.TestAsString(t, 2, "timeout", ErrRequestTimeout.Error(), result)
+}
@@ -0,0 +1,105 @@
+package broker
+
+import (
+	"sync/atomic"
+
+	"github.com/MrReality255/turbo-go/tg/utils"
+)
+
+type Stats struct {
+	QueueDepth    int
+	Members       map[uint32][]MemberStats
+	Subscriptions map[uint32][]Handle
+	Requests      []RequestStats
+	Delivered     int64
+	Timeouts      int64
+	Aborts        int64
+	DeadLetters   int64
+	Duplicates    int64
+}
+
+type MemberStats struct {
+	Handle   Handle
+	Mailbox  int
+	InFlight int
+}
+
+type RequestStats struct {
+	Member   Handle
+	Receiver Handle
+	Active   int
+}
+
+type counters struct {
+	delivered   atomic.Int64
+	timeouts    atomic.Int64
+	aborts      atomic.Int64
+	deadLetters atomic.Int64
+	duplicates  atomic.Int64
+}
+
+func (c *controller[Command]) Stats() Stats {
+	result := Stats{
+		QueueDepth:    c.queue.len(),
+		Members:       make(map[uint32][]MemberStats),
+		Subscriptions: make(map[uint32][]Handle),
+		Delivered:     c.counters.delivered.Load(),
+		Timeouts:      c.counters.timeouts.Load(),
+		Aborts:        c.counters.aborts.Load(),
+		DeadLetters:   c.counters.deadLetters.Load(),
+		Duplicates:    c.counters.duplicates.Load(),
+	}
+
+	var members []*memberWrapper[Command]
+	c.p.ExecLocked(func() {
+		members = utils.MapValues(c.members, func(item1 *memberWrapper[Command], item2 *memberWrapper[Command]) bool {
+			return item1.id < item2.id
+		})
+		for cmdType, subscribers := range c.subscribers {
+			list := utils.MapKeysIf(
+				subscribers,
+				func(_ Handle, ok bool) bool {
+					return ok
+				},
+				func(item1 Handle, item2 Handle) bool {
+					return item1 < item2
+				},
+			)
+			if len(list) > 0 {
+				result.Subscriptions[cmdType] = list
+			}
+		}
+	})
+
+	for _, m := range members {
+		typeID := m.id.GetTypeID()
+		result.Members[typeID] = append(result.Members[typeID], MemberStats{
+			Handle:   m.id,
+			Mailbox:  m.mailbox.len(),
+			InFlight: int(m.inFlight.Load()),
+		})
+		result.Requests = append(result.Requests, m.requestStats()...)
+	}
+	return result
+}
+
+func (m *memberWrapper[Command]) requestStats() []RequestStats {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	var result []RequestStats
+	for _, receiver := range utils.MapKeys(m.reqManager, func(item1 Handle, item2 Handle) bool {
+		return item1 < item2
+	}) {
+		if count := m.reqManager[receiver].activeCount(); count > 0 {
+			result = append(result, RequestStats{Member: m.id, Receiver: receiver, Active: count})
+		}
+	}
+	return result
+}
+
+func (m *requestManager[Command]) activeCount() int {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	return len(m.activeRequests)
+}
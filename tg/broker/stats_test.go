@@ -0,0 +1,47 @@
+package broker
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/MrReality255/turbo-go/tg/utils"
+)
+
+func TestStats(t *testing.T) {
+	var (
+		sim    = NewSimulation(1, time.Unix(0, 0))
+		b      = New(testDescriptor, 50*time.Millisecond, WithSimulation[testCmd](sim))
+		client = b.AddMember(NewHandle(testTypeClient, 1), nil)
+		silent = NewHandle(testTypeWorker, 2)
+	)
+	defer b.Close()
+
+	b.AddMember(NewHandle(testTypeWorker, 1), echoHandler).Subscribe(testTypeEvent)
+	b.AddMember(silent, nil)
+
+	chDone := make(chan error, 2)
+	handler := func(cmd testCmd, err error) bool {
+		chDone <- err
+		return true
+	}
+	client.RequestMultiple(NewHandle(testTypeWorker, 1), newTestCmd(testTypeWorker, "ping"), handler)
+	client.RequestMultiple(silent, newTestCmd(testTypeWorker, "ignored"), handler)
+	sim.Run()
+	utils.TestAsString(t, 1, "ping", "<nil>", <-chDone)
+
+	stats := b.Stats()
+	utils.TestAsString(t, 2, "members", "1 2", fmt.Sprintf("%v %v", len(stats.Members[testTypeClient]), len(stats.Members[testTypeWorker])))
+	utils.TestAsString(t, 3, "subscriptions", fmt.Sprintf("[%v]", NewHandle(testTypeWorker, 1)), stats.Subscriptions[testTypeEvent])
+	utils.TestAsString(
+		t, 4, "requests",
+		fmt.Sprintf("[{%v %v 1}]", NewHandle(testTypeClient, 1), silent),
+		stats.Requests,
+	)
+	utils.TestAsString(t, 5, "delivered", "3", stats.Delivered)
+
+	sim.Advance(50 * time.Millisecond)
+	utils.TestAsString(t, 6, "timeout", ErrRequestTimeout.Error(), <-chDone)
+	stats = b.Stats()
+	utils.TestAsString(t, 7, "counters", "0 1 0", fmt.Sprintf("%v %v %v", len(stats.Requests), stats.Timeouts, stats.Aborts))
+}
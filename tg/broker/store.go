@@ -0,0 +1,289 @@
+package broker
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/MrReality255/turbo-go/tg/log"
+	"github.com/MrReality255/turbo-go/tg/utils"
+)
+
+var (
+	ErrStoreClosed  = errors.New("message store closed")
+	ErrStoreCorrupt = errors.New("message store corrupt")
+)
+
+type storeRecordKind uint8
+
+const (
+	storeRecordAppend storeRecordKind = 1
+	storeRecordRemove storeRecordKind = 2
+)
+
+// IMessageStore keeps queued messages until they are dispatched, so they survive a crash
+type IMessageStore[Command ICommand] interface {
+	Append(sender Handle, receiver Handle, cmd Command) (uint64, error)
+	Remove(seqID uint64) error
+	Replay(fct func(seqID uint64, sender Handle, receiver Handle, cmd Command)) error
+	Close() error
+}
+
+type FileStoreConfig struct {
+	Filename   string
+	SyncWrites bool
+}
+
+type storeRecord struct {
+	kind     storeRecordKind
+	seqID    uint64
+	sender   Handle
+	receiver Handle
+	payload  []byte
+}
+
+type fileStore[Command ICommand] struct {
+	cfg   FileStoreConfig
+	codec CommandCodec[Command]
+
+	mx           sync.Mutex
+	f            *os.File
+	lastSeqID    uint64
+	pendingCount int
+	replay       []*storeRecord
+}
+
+// NewFileStore opens an append-only message file. Messages that were appended but never removed
+// are kept for Replay, everything else is dropped from the file.
+func NewFileStore[Command ICommand](
+	cfg FileStoreConfig, codec CommandCodec[Command],
+) (IMessageStore[Command], error) {
+	s := &fileStore[Command]{cfg: cfg, codec: codec}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(cfg.Filename, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s.f = f
+	s.pendingCount = len(s.replay)
+	return s, nil
+}
+
+func WithStore[Command ICommand](store IMessageStore[Command]) Option[Command] {
+	return func(c *controller[Command]) {
+		c.store = store
+	}
+}
+
+func (c *controller[Command]) Replay() error {
+	if c.store == nil {
+		return nil
+	}
+	return c.store.Replay(func(seqID uint64, sender Handle, receiver Handle, cmd Command) {
+		// a message that can't be queued anymore stays in the store
+		utils.IgnoreErr(c.enqueue(&messageWrapper[Command]{
+			cmd:      cmd,
+			sender:   sender,
+			receiver: receiver,
+			seqID:    seqID,
+			priority: c.getPriority(cmd, sendOptions{}),
+		}))
+	})
+}
+
+func (c *controller[Command]) removeStored(msg *messageWrapper[Command]) {
+	if c.store == nil || msg.seqID == 0 {
+		return
+	}
+	err := c.store.Remove(msg.seqID)
+	if !errors.Is(err, ErrStoreClosed) {
+		log.IfError("unable to remove persisted message: %v", err)
+	}
+}
+
+func (s *fileStore[Command]) Append(sender Handle, receiver Handle, cmd Command) (uint64, error) {
+	payload, err := s.codec.Encode(cmd)
+	if err != nil {
+		return 0, err
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if s.f == nil {
+		return 0, ErrStoreClosed
+	}
+	s.lastSeqID++
+	rec := &storeRecord{
+		kind:     storeRecordAppend,
+		seqID:    s.lastSeqID,
+		sender:   sender,
+		receiver: receiver,
+		payload:  payload,
+	}
+	if err := s.writeLocked(rec); err != nil {
+		return 0, err
+	}
+	s.pendingCount++
+	return rec.seqID, nil
+}
+
+func (s *fileStore[Command]) Remove(seqID uint64) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if s.f == nil {
+		return ErrStoreClosed
+	}
+	if err := s.writeLocked(&storeRecord{kind: storeRecordRemove, seqID: seqID}); err != nil {
+		return err
+	}
+
+	// nothing is pending anymore: start with an empty file
+	s.pendingCount = max(s.pendingCount-1, 0)
+	if s.pendingCount == 0 && len(s.replay) == 0 {
+		return s.f.Truncate(0)
+	}
+	return nil
+}
+
+func (s *fileStore[Command]) Replay(fct func(seqID uint64, sender Handle, receiver Handle, cmd Command)) error {
+	var list []*storeRecord
+	utils.ExecLocked(&s.mx, func() {
+		list, s.replay = s.replay, nil
+	})
+
+	errList := utils.NewErrorList(len(list))
+	for _, rec := range list {
+		cmd, err := s.codec.Decode(rec.payload)
+		if err != nil {
+			errList.Add(err)
+			errList.Add(s.Remove(rec.seqID))
+			continue
+		}
+		fct(rec.seqID, rec.sender, rec.receiver, cmd)
+	}
+	return errList.Err()
+}
+
+func (s *fileStore[Command]) Close() error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	defer func() {
+		s.f = nil
+	}()
+	return s.f.Close()
+}
+
+func (s *fileStore[Command]) load() error {
+	if !utils.FileExists(s.cfg.Filename) {
+		return nil
+	}
+	f, err := os.Open(s.cfg.Filename)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		utils.IgnoreErr(f.Close())
+	}()
+
+	var (
+		r       = bufio.NewReader(f)
+		records = make(map[uint64]*storeRecord)
+	)
+	for {
+		rec, err := readStoreRecord(r)
+		if err != nil {
+			// a torn record at the end of the file is the result of a crash while writing
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				break
+			}
+			return err
+		}
+		s.lastSeqID = max(s.lastSeqID, rec.seqID)
+		switch rec.kind {
+		case storeRecordAppend:
+			records[rec.seqID] = rec
+		case storeRecordRemove:
+			delete(records, rec.seqID)
+		}
+	}
+	s.replay = utils.MapValues(records, func(item1 *storeRecord, item2 *storeRecord) bool {
+		return item1.seqID < item2.seqID
+	})
+	return nil
+}
+
+func (s *fileStore[Command]) compact() error {
+	tmpFile := s.cfg.Filename + ".tmp"
+	f, err := os.Create(tmpFile)
+	if err != nil {
+		return err
+	}
+	err = utils.CloseAfter(f, func() error {
+		w := bufio.NewWriter(f)
+		for _, rec := range s.replay {
+			if err := writeStoreRecord(w, rec); err != nil {
+				return err
+			}
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		return f.Sync()
+	})
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, s.cfg.Filename)
+}
+
+func (s *fileStore[Command]) writeLocked(rec *storeRecord) error {
+	if err := writeStoreRecord(s.f, rec); err != nil {
+		return err
+	}
+	if s.cfg.SyncWrites {
+		return s.f.Sync()
+	}
+	return nil
+}
+
+func readStoreRecord(r io.Reader) (*storeRecord, error) {
+	var (
+		rec         storeRecord
+		payloadSize uint32
+	)
+	if err := utils.FromReader(r, &rec.kind, &rec.seqID, &rec.sender, &rec.receiver, &payloadSize); err != nil {
+		return nil, err
+	}
+	if payloadSize > maxPayloadSize {
+		return nil, ErrStoreCorrupt
+	}
+	rec.payload = make([]byte, payloadSize)
+	if _, err := io.ReadFull(r, rec.payload); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+func writeStoreRecord(w io.Writer, rec *storeRecord) error {
+	b := new(bytes.Buffer)
+	err := utils.WriteBytes(
+		b, rec.kind, rec.seqID, rec.sender, rec.receiver, uint32(len(rec.payload)), rec.payload,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b.Bytes())
+	return err
+}
@@ -0,0 +1,69 @@
+package broker
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/MrReality255/turbo-go/tg/utils"
+)
+
+func TestFileStoreReplay(t *testing.T) {
+	var (
+		cfg      = FileStoreConfig{Filename: filepath.Join(t.TempDir(), "queue.bin")}
+		codec    = NewJSONCodec[testCmd]()
+		receiver = NewHandle(testTypeWorker, 1)
+	)
+
+	// simulate a crash: two messages are persisted, only the first one is delivered
+	store := utils.Must(NewFileStore(cfg, codec))
+	seqID := utils.Must(store.Append(NewHandle(testTypeClient, 1), receiver, newTestCmd(testTypeWorker, "first")))
+	utils.Must(store.Append(NewHandle(testTypeClient, 1), receiver, newTestCmd(testTypeWorker, "second")))
+	utils.MustSucceed(store.Remove(seqID))
+	utils.MustSucceed(store.Close())
+
+	chReceived := make(chan string, 2)
+	b := New(testDescriptor, time.Second, WithStore(utils.Must(NewFileStore(cfg, codec))))
+	b.AddMember(receiver, func(sender Handle, msg testCmd, member IMember[testCmd]) {
+		chReceived <- msg.Payload
+	})
+	utils.TestAsString(t, 1, "replay", "<nil>", b.Replay())
+	utils.TestAsString(t, 2, "received", "second", <-chReceived)
+
+	// the graceful shutdown waits until the delivered message is removed from the store
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	utils.MustSucceed(b.Shutdown(ctx))
+
+	// the replayed message was delivered, nothing is left for the next start
+	store = utils.Must(NewFileStore(cfg, codec))
+	defer func() {
+		utils.MustSucceed(store.Close())
+	}()
+	var count int
+	utils.MustSucceed(store.Replay(func(seqID uint64, sender Handle, receiver Handle, cmd testCmd) {
+		count++
+	}))
+	utils.TestAsString(t, 3, "pending", "0", count)
+}
+
+func TestStoreFailure(t *testing.T) {
+	var (
+		store    = utils.Must(NewFileStore(FileStoreConfig{Filename: filepath.Join(t.TempDir(), "queue.bin")}, NewJSONCodec[testCmd]()))
+		b        = New(testDescriptor, time.Second, WithStore(store))
+		received = make(chan string, 1)
+	)
+	defer b.Close()
+
+	receiver := NewHandle(testTypeWorker, 1)
+	b.AddMember(receiver, func(sender Handle, msg testCmd, member IMember[testCmd]) {
+		received <- msg.Payload
+	})
+	client := b.AddMember(NewHandle(testTypeClient, 1), nil)
+
+	utils.MustSucceed(store.Close())
+	err := client.Send(receiver, newTestCmd(testTypeWorker, "lost"))
+	utils.TestAsString(t, 1, "not persisted", ErrStoreClosed.Error(), err)
+	utils.TestAsString(t, 2, "not delivered", "0", len(received))
+}
@@ -0,0 +1,62 @@
+package broker
+
+import "slices"
+
+// SubscriptionFilter selects the messages a member receives. It runs while the broker is
+// locked, so it must be fast and must not call the broker.
+type SubscriptionFilter[Command ICommand] func(sender Handle, cmd Command) bool
+
+type filterSubscription[Command ICommand] struct {
+	filter SubscriptionFilter[Command]
+}
+
+type filterList[Command ICommand] []*filterSubscription[Command]
+
+// SenderTypeFilter accepts messages of senders with one of the given type IDs; the optional
+// filters must accept the message as well
+func SenderTypeFilter[Command ICommand](
+	typeIDs []uint32, filters ...SubscriptionFilter[Command],
+) SubscriptionFilter[Command] {
+	return func(sender Handle, cmd Command) bool {
+		if !slices.Contains(typeIDs, sender.GetTypeID()) {
+			return false
+		}
+		for _, filter := range filters {
+			if !filter(sender, cmd) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func (c *controller[Command]) subscribeFunc(subscriber Handle, filter SubscriptionFilter[Command]) func() {
+	sub := &filterSubscription[Command]{filter: filter}
+	c.p.ExecLocked(func() {
+		if c.members[subscriber] != nil {
+			c.filters[subscriber] = append(c.filters[subscriber], sub)
+		}
+	})
+
+	return func() {
+		c.p.ExecLocked(func() {
+			list := slices.DeleteFunc(c.filters[subscriber], func(item *filterSubscription[Command]) bool {
+				return item == sub
+			})
+			if len(list) == 0 {
+				delete(c.filters, subscriber)
+				return
+			}
+			c.filters[subscriber] = list
+		})
+	}
+}
+
+func (l filterList[Command]) match(sender Handle, cmd Command) bool {
+	for _, sub := range l {
+		if sub.filter(sender, cmd) {
+			return true
+		}
+	}
+	return false
+}
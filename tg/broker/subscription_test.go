@@ -0,0 +1,44 @@
+package broker
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/MrReality255/turbo-go/tg/utils"
+)
+
+func TestSubscriptions(t *testing.T) {
+	var (
+		b        = New(testDescriptor, time.Second)
+		received = make(chan string, 16)
+		client   = b.AddMember(NewHandle(testTypeClient, 1), nil)
+		worker   = b.AddMember(NewHandle(testTypeWorker, 1), nil)
+		listener = b.AddMember(NewHandle(testTypeClient, 2), func(sender Handle, msg testCmd, member IMember[testCmd]) {
+			received <- fmt.Sprintf("%v:%v", sender.GetTypeID(), msg.Payload)
+		})
+	)
+	defer b.Close()
+
+	listener.Subscribe(testTypeEvent)
+	utils.MustSucceed(client.Send(HandleAny, newTestCmd(testTypeEvent, "subscribed")))
+	utils.TestString(t, 1, "subscribe", "1:subscribed", <-received)
+
+	listener.Unsubscribe(testTypeEvent)
+	cancel := listener.SubscribeFunc(SenderTypeFilter(
+		[]uint32{testTypeWorker},
+		func(sender Handle, cmd testCmd) bool {
+			return cmd.Payload != "skipped"
+		},
+	))
+	for _, payload := range []string{"client", "skipped", "worker"} {
+		sender := utils.IfThen(payload == "client", client, worker)
+		utils.MustSucceed(sender.Send(HandleAny, newTestCmd(testTypeEvent, payload)))
+	}
+	utils.TestString(t, 2, "filter", "2:worker", <-received)
+
+	cancel()
+	utils.MustSucceed(worker.Send(HandleAny, newTestCmd(testTypeEvent, "cancelled")))
+	utils.MustSucceed(b.AddMember(NewHandle(testTypeWorker, 2), nil).Send(NewHandle(testTypeClient, 2), newTestCmd(testTypeEvent, "direct")))
+	utils.TestString(t, 3, "cancel", "2:direct", <-received)
+}
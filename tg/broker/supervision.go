@@ -0,0 +1,97 @@
+package broker
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/MrReality255/turbo-go/tg/log"
+)
+
+var (
+	ErrHandlerPanic = errors.New("handler panicked")
+)
+
+type SupervisionPolicy uint8
+
+const (
+	// SupervisionResume logs the failure, the member handles the next message
+	SupervisionResume SupervisionPolicy = 0
+	// SupervisionRemove closes the member
+	SupervisionRemove SupervisionPolicy = 1
+	// SupervisionRestart replaces the handler with a new one created by the restart factory
+	SupervisionRestart SupervisionPolicy = 2
+)
+
+type Failure[Command ICommand] struct {
+	Member Handle
+	Sender Handle
+	Cmd    Command
+	Err    error
+	Stack  string
+	Policy SupervisionPolicy
+}
+
+// WithSupervisor sends every handler failure, converted by wrap, to the supervisor member. The
+// failures of the supervisor itself are only logged.
+func WithSupervisor[Command ICommand](supervisor Handle, wrap func(f Failure[Command]) Command) Option[Command] {
+	return func(c *controller[Command]) {
+		c.supervisor = supervisor
+		c.wrapFailure = wrap
+	}
+}
+
+func WithSupervision[Command ICommand](policy SupervisionPolicy) MemberOption[Command] {
+	return func(m *memberWrapper[Command]) {
+		m.policy = policy
+	}
+}
+
+// WithRestart sets the SupervisionRestart policy; factory creates the handler after a failure
+func WithRestart[Command ICommand](factory func() MemberMessageHandler[Command]) MemberOption[Command] {
+	return func(m *memberWrapper[Command]) {
+		m.policy = SupervisionRestart
+		m.restartFct = factory
+	}
+}
+
+func (m *memberWrapper[Command]) recoverHandler(msg *messageWrapper[Command]) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	f := Failure[Command]{
+		Member: m.id,
+		Sender: msg.sender,
+		Cmd:    msg.cmd,
+		Err:    fmt.Errorf("%w: %v", ErrHandlerPanic, r),
+		Stack:  string(debug.Stack()),
+		Policy: m.policy,
+	}
+	log.Warn("handler of member %v failed: %v", m.id, f.Err)
+
+	switch {
+	case f.Policy == SupervisionRemove:
+		m.Close()
+	case f.Policy == SupervisionRestart && m.restartFct != nil:
+		m.setHandler(m.restartFct())
+	}
+
+	c := m.broker
+	if c.wrapFailure != nil && m.id != c.supervisor {
+		log.IfError("unable to report failure: %v", c.send(m.id, c.supervisor, c.wrapFailure(f), sendOptions{}))
+	}
+}
+
+func (m *memberWrapper[Command]) handler() MemberMessageHandler[Command] {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	return m.messageHandler
+}
+
+func (m *memberWrapper[Command]) setHandler(handler MemberMessageHandler[Command]) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	m.messageHandler = handler
+}
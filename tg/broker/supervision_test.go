@@ -0,0 +1,63 @@
+package broker
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/MrReality255/turbo-go/tg/utils"
+)
+
+const (
+	testTypeFailure = 22
+)
+
+func TestSupervision(t *testing.T) {
+	var (
+		supervisor = NewHandle(testTypeClient, 2)
+		failures   = make(chan string, 3)
+		received   = make(chan string, 3)
+		b          = New(
+			testDescriptor,
+			time.Second,
+			WithSupervisor(supervisor, func(f Failure[testCmd]) testCmd {
+				return newTestCmd(testTypeFailure, fmt.Sprintf("%v %v %v", f.Member, f.Policy, f.Cmd.Payload))
+			}),
+		)
+		restarts atomic.Int32
+	)
+	defer b.Close()
+
+	client := b.AddMember(NewHandle(testTypeClient, 1), nil)
+	b.AddMember(supervisor, func(sender Handle, msg testCmd, member IMember[testCmd]) {
+		failures <- msg.Payload
+	})
+	handler := func(sender Handle, msg testCmd, member IMember[testCmd]) {
+		if msg.Payload == "boom" {
+			panic("boom")
+		}
+		received <- fmt.Sprintf("%v %v", msg.ID.GetTypeID(), msg.Payload)
+	}
+	resumed := NewHandle(testTypeWorker, 1)
+	removed := NewHandle(testTypeWorker, 2)
+	restarted := NewHandle(testTypeWorker, 3)
+	b.AddMember(resumed, handler)
+	b.AddMember(removed, handler, WithSupervision[testCmd](SupervisionRemove))
+	b.AddMember(restarted, handler, WithRestart(func() MemberMessageHandler[testCmd] {
+		restarts.Add(1)
+		return handler
+	}))
+
+	for i, receiver := range []Handle{resumed, removed, restarted} {
+		utils.MustSucceed(client.Send(receiver, newTestCmd(testTypeWorker, "boom")))
+		utils.TestString(t, i+1, "failure", fmt.Sprintf("%v %v boom", receiver, i), <-failures)
+	}
+
+	utils.MustSucceed(client.Send(resumed, newTestCmd(testTypeWorker, "resumed")))
+	utils.TestString(t, 4, "resumed", fmt.Sprintf("%v resumed", testTypeWorker), <-received)
+	utils.MustSucceed(client.Send(restarted, newTestCmd(testTypeWorker, "restarted")))
+	utils.TestString(t, 5, "restarted", fmt.Sprintf("%v restarted", testTypeWorker), <-received)
+	utils.TestAsString(t, 6, "restarts", "1", restarts.Load())
+	utils.TestAsString(t, 7, "removed", fmt.Sprintf("[%v %v]", resumed, restarted), b.ListMembers(testTypeWorker))
+}
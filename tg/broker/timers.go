@@ -0,0 +1,170 @@
+package broker
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// timerQueue runs functions at a given time; all timers of a broker share one goroutine, which
+// hands the due functions to spawn so a blocking one doesn't hold up the others
+type timerQueue struct {
+	mx       sync.Mutex
+	entries  timerHeap
+	chWake   chan bool
+	chClose  chan bool
+	isClosed bool
+	spawn    func(fct func())
+}
+
+type timerEntry struct {
+	at    time.Time
+	fct   func()
+	index int
+}
+
+type timerHeap []*timerEntry
+
+func newTimerQueue(spawn func(fct func())) *timerQueue {
+	q := &timerQueue{
+		chWake:  make(chan bool, 1),
+		chClose: make(chan bool),
+		spawn:   spawn,
+	}
+	go q.run()
+	return q
+}
+
+func (q *timerQueue) Now() time.Time {
+	return time.Now()
+}
+
+func (q *timerQueue) AfterFunc(d time.Duration, fct func()) func() bool {
+	entry := q.schedule(time.Now().Add(d), fct)
+	return func() bool {
+		return entry != nil && q.cancel(entry)
+	}
+}
+
+// schedule returns nil if the queue is closed
+func (q *timerQueue) schedule(at time.Time, fct func()) *timerEntry {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	if q.isClosed {
+		return nil
+	}
+
+	entry := &timerEntry{at: at, fct: fct}
+	heap.Push(&q.entries, entry)
+	if entry.index == 0 {
+		q.wake()
+	}
+	return entry
+}
+
+// cancel reports whether the entry was still pending
+func (q *timerQueue) cancel(entry *timerEntry) bool {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	if entry.index < 0 {
+		return false
+	}
+	heap.Remove(&q.entries, entry.index)
+	return true
+}
+
+func (q *timerQueue) len() int {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	return len(q.entries)
+}
+
+// close stops the queue, the pending entries are dropped
+func (q *timerQueue) close() {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	if q.isClosed {
+		return
+	}
+	q.isClosed = true
+	for _, entry := range q.entries {
+		entry.index = -1
+	}
+	q.entries = nil
+	close(q.chClose)
+}
+
+func (q *timerQueue) wake() {
+	select {
+	case q.chWake <- true:
+	default:
+	}
+}
+
+func (q *timerQueue) run() {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		due, next := q.popDue(time.Now())
+		for _, entry := range due {
+			q.spawn(entry.fct)
+		}
+		if len(due) > 0 {
+			continue
+		}
+
+		timer.Reset(next)
+		select {
+		case <-q.chClose:
+			return
+		case <-q.chWake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// popDue removes the entries that are due and returns the time until the next one
+func (q *timerQueue) popDue(now time.Time) ([]*timerEntry, time.Duration) {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	var due []*timerEntry
+	for len(q.entries) > 0 && !q.entries[0].at.After(now) {
+		due = append(due, heap.Pop(&q.entries).(*timerEntry))
+	}
+	if len(q.entries) == 0 {
+		return due, time.Hour
+	}
+	return due, q.entries[0].at.Sub(now)
+}
+
+func (h timerHeap) Len() int {
+	return len(h)
+}
+
+func (h timerHeap) Less(i, j int) bool {
+	return h[i].at.Before(h[j].at)
+}
+
+func (h timerHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *timerHeap) Push(x any) {
+	entry := x.(*timerEntry)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *timerHeap) Pop() any {
+	old := *h
+	entry := old[len(old)-1]
+	old[len(old)-1] = nil
+	entry.index = -1
+	*h = old[:len(old)-1]
+	return entry
+}
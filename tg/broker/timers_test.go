@@ -0,0 +1,33 @@
+package broker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/MrReality255/turbo-go/tg/utils"
+)
+
+// a function of the queue that blocks must not hold up the later ones
+func TestTimerQueueBlocked(t *testing.T) {
+	var (
+		q         = newTimerQueue(goSpawn)
+		chRelease = make(chan bool)
+		chFired   = make(chan string, 1)
+	)
+	defer q.close()
+	defer close(chRelease)
+
+	q.AfterFunc(time.Millisecond, func() {
+		<-chRelease
+	})
+	q.AfterFunc(5*time.Millisecond, func() {
+		chFired <- "later"
+	})
+
+	select {
+	case s := <-chFired:
+		utils.TestString(t, 1, "fired", "later", s)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the blocked function holds up the queue")
+	}
+}
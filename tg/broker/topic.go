@@ -0,0 +1,122 @@
+package broker
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrInvalidTopic = errors.New("invalid topic pattern")
+)
+
+const (
+	topicSeparator  = "/"
+	topicSingleWild = "+"
+	topicMultiWild  = "#"
+)
+
+type topicSubscription struct {
+	levels      []string
+	subscribers map[Handle]bool
+}
+
+// subscribeTopic subscribes to topic patterns with MQTT-style wildcards: "+" matches one level,
+// "#" as the last level matches any number of levels
+func (c *controller[Command]) subscribeTopic(subscriber Handle, patterns ...string) error {
+	for _, pattern := range patterns {
+		if err := validateTopic(pattern); err != nil {
+			return err
+		}
+	}
+	c.p.ExecLocked(func() {
+		for _, pattern := range patterns {
+			sub := c.topics[pattern]
+			if sub == nil {
+				sub = &topicSubscription{
+					levels:      strings.Split(pattern, topicSeparator),
+					subscribers: make(map[Handle]bool),
+				}
+				c.topics[pattern] = sub
+			}
+			sub.subscribers[subscriber] = true
+		}
+	})
+	return nil
+}
+
+func (c *controller[Command]) unsubscribeTopic(subscriber Handle, patterns ...string) {
+	c.p.ExecLocked(func() {
+		for _, pattern := range patterns {
+			c.removeTopicLocked(pattern, subscriber)
+		}
+	})
+}
+
+func (c *controller[Command]) removeTopicLocked(pattern string, subscriber Handle) {
+	if sub := c.topics[pattern]; sub != nil {
+		delete(sub.subscribers, subscriber)
+		if len(sub.subscribers) == 0 {
+			delete(c.topics, pattern)
+		}
+	}
+}
+
+// topicSubscribersLocked returns the subscribers of all patterns matching the topic of the command
+func (c *controller[Command]) topicSubscribersLocked(cmd Command) []Handle {
+	if c.descriptor.GetTopic == nil || len(c.topics) == 0 {
+		return nil
+	}
+	topic := c.descriptor.GetTopic(cmd)
+	if topic == "" {
+		return nil
+	}
+
+	var (
+		levels = strings.Split(topic, topicSeparator)
+		result []Handle
+	)
+	for _, sub := range c.topics {
+		if matchTopic(sub.levels, levels) {
+			for subscriber := range sub.subscribers {
+				result = append(result, subscriber)
+			}
+		}
+	}
+	return result
+}
+
+func (m *memberWrapper[Command]) SubscribeTopic(patterns ...string) error {
+	return m.broker.subscribeTopic(m.id, patterns...)
+}
+
+func (m *memberWrapper[Command]) UnsubscribeTopic(patterns ...string) {
+	m.broker.unsubscribeTopic(m.id, patterns...)
+}
+
+func validateTopic(pattern string) error {
+	levels := strings.Split(pattern, topicSeparator)
+	for i, level := range levels {
+		switch {
+		case level == topicMultiWild && i != len(levels)-1:
+			return fmt.Errorf("%w: %v must be the last level of %v", ErrInvalidTopic, topicMultiWild, pattern)
+		case len(level) > 1 && strings.ContainsAny(level, topicSingleWild+topicMultiWild):
+			return fmt.Errorf("%w: wildcards must be a whole level of %v", ErrInvalidTopic, pattern)
+		}
+	}
+	return nil
+}
+
+func matchTopic(pattern []string, topic []string) bool {
+	for i, level := range pattern {
+		switch {
+		case level == topicMultiWild:
+			return true
+		case i >= len(topic):
+			return false
+		case level != topicSingleWild && level != topic[i]:
+			return false
+		}
+	}
+	return len(pattern) == len(topic)
+}
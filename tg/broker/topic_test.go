@@ -0,0 +1,49 @@
+package broker
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/MrReality255/turbo-go/tg/utils"
+)
+
+func TestTopics(t *testing.T) {
+	descriptor := testDescriptor
+	descriptor.GetTopic = func(cmd testCmd) string {
+		return cmd.Payload
+	}
+	var (
+		b        = New(descriptor, time.Second)
+		received = utils.NewStringList(16, false)
+		chDone   = make(chan bool, 16)
+		client   = b.AddMember(NewHandle(testTypeClient, 1), nil)
+	)
+	defer b.Close()
+
+	addMember := func(seqID uint32) IMember[testCmd] {
+		return b.AddMember(NewHandle(testTypeWorker, seqID), func(sender Handle, msg testCmd, member IMember[testCmd]) {
+			received.Addf("%v %v", seqID, msg.Payload)
+			chDone <- true
+		})
+	}
+	utils.MustSucceed(addMember(1).SubscribeTopic("orders/+/created"))
+	utils.MustSucceed(addMember(2).SubscribeTopic("orders/#"))
+	addMember(3).Subscribe(testTypeEvent)
+
+	for _, topic := range []string{"orders/eu/created", "orders/eu/cancelled", "orders"} {
+		utils.MustSucceed(client.Send(HandleAny, newTestCmd(testTypeEvent, topic)))
+	}
+	for range 7 {
+		<-chDone
+	}
+	utils.TestString(
+		t, 1, "received",
+		"1 orders/eu/created,2 orders,2 orders/eu/cancelled,2 orders/eu/created,3 orders,3 orders/eu/cancelled,3 orders/eu/created",
+		received.SortJoin(","),
+	)
+
+	err := client.SubscribeTopic("orders/#/created")
+	utils.TestAsString(t, 2, "invalid", fmt.Sprintf("%v: # must be the last level of orders/#/created", ErrInvalidTopic), err)
+	utils.TestAsString(t, 3, "invalid", "true", utils.IsErr(client.SubscribeTopic("orders/eu+"), ErrInvalidTopic))
+}
@@ -0,0 +1,320 @@
+package broker
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MrReality255/turbo-go/tg/utils"
+)
+
+const (
+	SpanSend    = "send"
+	SpanRequest = "request"
+
+	OutcomeOK        = "ok"
+	OutcomeTimeout   = "timeout"
+	OutcomeAborted   = "aborted"
+	OutcomeCancelled = "cancelled"
+	OutcomeRejected  = "rejected"
+	OutcomeFailed    = "failed"
+)
+
+// SpanRecord describes a message from sending until it was handled, or a request from sending
+// until it was completed
+type SpanRecord struct {
+	TraceID  uint64    `json:"trace"`
+	SpanID   uint64    `json:"span"`
+	ParentID uint64    `json:"parent,omitempty"`
+	Kind     string    `json:"kind"`
+	Sender   Handle    `json:"sender"`
+	Receiver Handle    `json:"receiver"`
+	CmdType  uint32    `json:"cmdType"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Outcome  string    `json:"outcome"`
+}
+
+type ISpanExporter interface {
+	Export(rec SpanRecord)
+}
+
+type jsonLinesExporter struct {
+	mx  sync.Mutex
+	enc *json.Encoder
+	err error
+}
+
+type traceSpan struct {
+	traceID   uint64
+	spanID    uint64
+	parentID  uint64
+	start     time.Time
+	isRequest bool
+}
+
+type spanKey struct{}
+
+// tracedMember is passed to a handler, the messages it sends belong to the handled span
+type tracedMember[Command ICommand] struct {
+	*memberWrapper[Command]
+	span *traceSpan
+}
+
+// WithTracing records a span for every message and request, the messages sent while handling
+// a message or a request are children of its span
+func WithTracing[Command ICommand](exporter ISpanExporter) Option[Command] {
+	return func(c *controller[Command]) {
+		c.exporter = exporter
+	}
+}
+
+// NewJSONLinesExporter writes every record as a JSON line; the first write error stops the export
+func NewJSONLinesExporter(w io.Writer) ISpanExporter {
+	return &jsonLinesExporter{enc: json.NewEncoder(w)}
+}
+
+func (e *jsonLinesExporter) Export(rec SpanRecord) {
+	e.mx.Lock()
+	defer e.mx.Unlock()
+	if e.err == nil {
+		e.err = e.enc.Encode(rec)
+	}
+}
+
+func ReadSpans(r io.Reader) ([]SpanRecord, error) {
+	var (
+		result  []SpanRecord
+		scanner = bufio.NewScanner(r)
+	)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var rec SpanRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return result, err
+		}
+		result = append(result, rec)
+	}
+	return result, scanner.Err()
+}
+
+// PrintCallTrees prints the spans as one tree per trace, the children are ordered by start
+func PrintCallTrees(w io.Writer, spans []SpanRecord) error {
+	var (
+		children = make(map[uint64][]SpanRecord)
+		known    = make(map[uint64]bool)
+		roots    []SpanRecord
+	)
+	for _, rec := range spans {
+		known[rec.SpanID] = true
+	}
+	for _, rec := range spans {
+		if rec.ParentID == 0 || !known[rec.ParentID] {
+			roots = append(roots, rec)
+			continue
+		}
+		children[rec.ParentID] = append(children[rec.ParentID], rec)
+	}
+
+	byStart := func(a, b SpanRecord) int {
+		return a.Start.Compare(b.Start)
+	}
+	slices.SortStableFunc(roots, byStart)
+	for _, list := range children {
+		slices.SortStableFunc(list, byStart)
+	}
+
+	var print func(rec SpanRecord, depth int) error
+	print = func(rec SpanRecord, depth int) error {
+		_, err := fmt.Fprintf(
+			w, "%v%v %v -> %v type %v [%v %v]\n",
+			strings.Repeat("  ", depth), rec.Kind, formatHandle(rec.Sender), formatHandle(rec.Receiver),
+			rec.CmdType, rec.Outcome, rec.End.Sub(rec.Start),
+		)
+		for _, child := range children[rec.SpanID] {
+			if err != nil {
+				return err
+			}
+			err = print(child, depth+1)
+		}
+		return err
+	}
+
+	for _, root := range roots {
+		if _, err := fmt.Fprintf(w, "trace %x\n", root.TraceID); err != nil {
+			return err
+		}
+		if err := print(root, 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *controller[Command]) newSpan(parent *traceSpan, isRequest bool) *traceSpan {
+	if c.exporter == nil {
+		return nil
+	}
+	sp := &traceSpan{spanID: rand.Uint64(), start: c.clock.Now(), isRequest: isRequest}
+	if parent != nil {
+		sp.traceID, sp.parentID = parent.traceID, parent.spanID
+	} else {
+		sp.traceID = rand.Uint64()
+	}
+	return sp
+}
+
+// forDelivery gives every delivery of a broadcast message its own send span with the same parent
+func (msg *messageWrapper[Command]) forDelivery(receivers int) *messageWrapper[Command] {
+	if receivers < 2 || msg.span == nil || msg.span.isRequest {
+		return msg
+	}
+	sp := *msg.span
+	sp.spanID = rand.Uint64()
+	result := *msg
+	result.span = &sp
+	return &result
+}
+
+func (c *controller[Command]) exportSpan(
+	sp *traceSpan, sender Handle, receiver Handle, cmd Command, outcome string,
+) {
+	if sp == nil {
+		return
+	}
+	c.exporter.Export(SpanRecord{
+		TraceID:  sp.traceID,
+		SpanID:   sp.spanID,
+		ParentID: sp.parentID,
+		Kind:     utils.IfThen(sp.isRequest, SpanRequest, SpanSend),
+		Sender:   sender,
+		Receiver: receiver,
+		CmdType:  c.descriptor.GetID(cmd).GetTypeID(),
+		Start:    sp.start,
+		End:      c.clock.Now(),
+		Outcome:  outcome,
+	})
+}
+
+// traceRequest starts the span of a request and records it once the request is completed
+func (m *memberWrapper[Command]) traceRequest(
+	ctx context.Context, receiver Handle, cmd Command, handler RequestHandler[Command],
+) (context.Context, RequestHandler[Command]) {
+	sp := m.broker.newSpan(spanFromContext(ctx), true)
+	if sp == nil {
+		return ctx, handler
+	}
+
+	var once sync.Once
+	return withSpan(ctx, sp), func(responseCmd Command, err error) bool {
+		isDone := handler(responseCmd, err)
+		if isDone || err != nil {
+			once.Do(func() {
+				m.broker.exportSpan(sp, m.id, receiver, cmd, getOutcome(err))
+			})
+		}
+		return isDone
+	}
+}
+
+// withSpan returns the member that is passed to the handler of a message
+func (m *memberWrapper[Command]) withSpan(sp *traceSpan) IMember[Command] {
+	if sp == nil {
+		return m
+	}
+	return &tracedMember[Command]{memberWrapper: m, span: sp}
+}
+
+func (t *tracedMember[Command]) Request(receiver Handle, cmd Command, options ...RequestOption) (Command, error) {
+	return t.memberWrapper.RequestCtx(withSpan(context.Background(), t.span), receiver, cmd, options...)
+}
+
+func (t *tracedMember[Command]) RequestCtx(
+	ctx context.Context, receiver Handle, cmd Command, options ...RequestOption,
+) (Command, error) {
+	return t.memberWrapper.RequestCtx(withSpan(ctx, t.span), receiver, cmd, options...)
+}
+
+func (t *tracedMember[Command]) RequestMultiple(receiver Handle, cmd Command, handler RequestHandler[Command]) {
+	t.memberWrapper.RequestMultipleCtx(withSpan(context.Background(), t.span), receiver, cmd, handler)
+}
+
+func (t *tracedMember[Command]) RequestMultipleCtx(
+	ctx context.Context, receiver Handle, cmd Command, handler RequestHandler[Command],
+) {
+	t.memberWrapper.RequestMultipleCtx(withSpan(ctx, t.span), receiver, cmd, handler)
+}
+
+func (t *tracedMember[Command]) RequestStream(receiver Handle, cmd Command) iter.Seq2[Command, error] {
+	return streamRequest(t.descriptor.IsLast, func(ctx context.Context, handler RequestHandler[Command]) {
+		t.RequestMultipleCtx(ctx, receiver, cmd, handler)
+	})
+}
+
+func (t *tracedMember[Command]) Gather(
+	ctx context.Context, typeID uint32, cmd Command, quorum Quorum,
+) (GatherResult[Command], error) {
+	return t.memberWrapper.Gather(withSpan(ctx, t.span), typeID, cmd, quorum)
+}
+
+func (t *tracedMember[Command]) Send(receiver Handle, cmd Command, options ...SendOption) error {
+	return t.memberWrapper.Send(receiver, cmd, append(options, withParentSpan(t.span))...)
+}
+
+func (t *tracedMember[Command]) SendAfter(
+	receiver Handle, cmd Command, delay time.Duration, options ...SendOption,
+) (IScheduled, error) {
+	return t.memberWrapper.SendAfter(receiver, cmd, delay, append(options, withParentSpan(t.span))...)
+}
+
+func (t *tracedMember[Command]) SendAt(
+	receiver Handle, cmd Command, at time.Time, options ...SendOption,
+) (IScheduled, error) {
+	return t.memberWrapper.SendAt(receiver, cmd, at, append(options, withParentSpan(t.span))...)
+}
+
+func withParentSpan(sp *traceSpan) SendOption {
+	return func(o *sendOptions) {
+		o.parent = sp
+	}
+}
+
+func withSpan(ctx context.Context, sp *traceSpan) context.Context {
+	return context.WithValue(ctx, spanKey{}, sp)
+}
+
+func spanFromContext(ctx context.Context) *traceSpan {
+	sp, _ := ctx.Value(spanKey{}).(*traceSpan)
+	return sp
+}
+
+func getOutcome(err error) string {
+	switch {
+	case err == nil:
+		return OutcomeOK
+	case errors.Is(err, ErrRequestTimeout), errors.Is(err, context.DeadlineExceeded):
+		return OutcomeTimeout
+	case errors.Is(err, ErrRequestAborted):
+		return OutcomeAborted
+	case errors.Is(err, context.Canceled):
+		return OutcomeCancelled
+	default:
+		return OutcomeFailed
+	}
+}
+
+func formatHandle(h Handle) string {
+	return fmt.Sprintf("%v:%v", h.GetTypeID(), h.GetSeqID())
+}
@@ -0,0 +1,81 @@
+package broker
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MrReality255/turbo-go/tg/utils"
+)
+
+func TestTracing(t *testing.T) {
+	var (
+		buf    bytes.Buffer
+		sim    = NewSimulation(1, time.Unix(0, 0))
+		b      = New(testDescriptor, time.Second, WithSimulation[testCmd](sim), WithTracing[testCmd](NewJSONLinesExporter(&buf)))
+		client = b.AddMember(NewHandle(testTypeClient, 1), nil)
+	)
+	defer b.Close()
+
+	b.AddMember(NewHandle(testTypeWorker, 1), func(sender Handle, msg testCmd, member IMember[testCmd]) {
+		utils.MustSucceed(member.Send(NewHandle(testTypeEvent, 1), newTestCmd(testTypeEvent, "notify")))
+		member.RequestMultiple(NewHandle(testTypeWorker, 2), newTestCmd(testTypeWorker, "nested"), func(cmd testCmd, err error) bool {
+			utils.MustSucceed(member.Send(sender, msg.reply(cmd.Payload)))
+			return true
+		})
+		member.RequestMultiple(NewHandle(testTypeWorker, 3), newTestCmd(testTypeWorker, "lost"), func(cmd testCmd, err error) bool {
+			return true
+		})
+	})
+	b.AddMember(NewHandle(testTypeWorker, 2), echoHandler)
+	b.AddMember(NewHandle(testTypeWorker, 3), nil)
+	b.AddMember(NewHandle(testTypeEvent, 1), nil)
+
+	client.RequestMultiple(NewHandle(testTypeWorker, 1), newTestCmd(testTypeWorker, "outer"), func(cmd testCmd, err error) bool {
+		return true
+	})
+	sim.Run()
+	sim.Advance(time.Second)
+
+	spans := utils.Must(ReadSpans(&buf))
+	utils.TestAsString(t, 1, "spans", "4", len(spans))
+
+	var out strings.Builder
+	utils.MustSucceed(PrintCallTrees(&out, spans))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	utils.TestString(
+		t, 2, "tree",
+		strings.Join([]string{
+			"  request 1:1 -> 2:1 type 2 [ok 0s]",
+			"    send 2:1 -> 10:1 type 10 [ok 0s]",
+			"    request 2:1 -> 2:2 type 2 [ok 0s]",
+			"    request 2:1 -> 2:3 type 2 [timeout 1s]",
+		}, "\n"),
+		strings.Join(lines[1:], "\n"),
+	)
+}
+
+func TestTracingBroadcast(t *testing.T) {
+	var (
+		buf    bytes.Buffer
+		sim    = NewSimulation(1, time.Unix(0, 0))
+		b      = New(testDescriptor, time.Second, WithSimulation[testCmd](sim), WithTracing[testCmd](NewJSONLinesExporter(&buf)))
+		client = b.AddMember(NewHandle(testTypeClient, 1), nil)
+	)
+	defer b.Close()
+
+	for i := range 2 {
+		b.AddMember(NewHandle(testTypeWorker, uint32(i+1)), nil).Subscribe(testTypeEvent)
+	}
+	utils.MustSucceed(client.Send(HandleAny, newTestCmd(testTypeEvent, "broadcast")))
+	sim.Run()
+
+	// one span per delivery, in the same trace
+	spans := utils.Must(ReadSpans(&buf))
+	utils.TestAsString(
+		t, 1, "spans", "2 true false",
+		fmt.Sprintf("%v %v %v", len(spans), spans[0].TraceID == spans[1].TraceID, spans[0].SpanID == spans[1].SpanID),
+	)
+}
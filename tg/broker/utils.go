@@ -1,5 +1,7 @@
 package broker
 
+import "encoding/json"
+
 const (
 	HandleAny = 0
 )
@@ -11,6 +13,35 @@ type ICommand any
 type CommandDescriptor[Command ICommand] struct {
 	GetID  func(cmd Command) Handle
 	GetRef func(cmd Command) Handle
+	// GetKey is optional, it returns the routing key used by NewHashBalancer
+	GetKey func(cmd Command) string
+	// GetPriority is optional, it returns the priority of the command in the broker queue
+	GetPriority func(cmd Command) Priority
+	// GetTopic is optional, it returns the topic matched against the topic subscriptions
+	GetTopic func(cmd Command) string
+	// Reissue is optional, it returns a copy of the command with a new ID; retried requests need it
+	Reissue func(cmd Command) Command
+	// IsLast is optional, it reports whether a response ends a request stream; without it a
+	// stream only ends with the idle timeout
+	IsLast func(cmd Command) bool
+}
+
+type CommandCodec[Command ICommand] struct {
+	Encode func(cmd Command) ([]byte, error)
+	Decode func(data []byte) (Command, error)
+}
+
+func NewJSONCodec[Command ICommand]() CommandCodec[Command] {
+	return CommandCodec[Command]{
+		Encode: func(cmd Command) ([]byte, error) {
+			return json.Marshal(cmd)
+		},
+		Decode: func(data []byte) (Command, error) {
+			var cmd Command
+			err := json.Unmarshal(data, &cmd)
+			return cmd, err
+		},
+	}
 }
 
 func (h Handle) GetTypeID() uint32 {